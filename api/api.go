@@ -96,4 +96,25 @@ type StorageFacade interface{
 	Stream(f func(key, item []byte))
 }
 
+/*
+Optionally implemented by StorageFacades, that can remove key-item-pairs.
 
+Deleting is not part of the data model (a deletion is an Item itself), but it
+is needed to drop keys, a node no longer owns.
+*/
+type Deleter interface{
+	/* Removes the key-item-pair. Returns false, if the store was not writable. */
+	Delete(key []byte) (ok bool)
+}
+
+/*
+Optionally implemented by StorageFacades, that can remove a key-item-pair
+atomically, if it did not change.
+*/
+type CompareDeleter interface{
+	/*
+	Removes the key-item-pair, if the stored item equals 'item'. Returns false,
+	if it differs or the store was not writable.
+	*/
+	DeleteIf(key, item []byte) (deleted bool)
+}
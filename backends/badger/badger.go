@@ -25,8 +25,12 @@ package bakbadger
 
 import "github.com/byte-mug/brute/api"
 import "github.com/dgraph-io/badger"
+import "bytes"
+import "errors"
 import "sync"
 
+var errChanged = errors.New("bakbadger: item changed")
+
 type Badger struct{
 	DB      *badger.DB
 	Merger  api.MergerFactory
@@ -84,6 +88,27 @@ func (b *Badger) Obtain(key []byte) (item []byte,ok,readable bool) {
 	})
 	return
 }
+/* Removes a key-item-pair, eg. after its key moved to another node. */
+func (b *Badger) Delete(key []byte) (ok bool) {
+	b.wlock.Lock(); defer b.wlock.Unlock()
+	err := b.DB.Update(func(txn *badger.Txn) error{
+		return txn.Delete(key)
+	})
+	return err==nil
+}
+/* Removes a key-item-pair, if the stored item equals 'item'. */
+func (b *Badger) DeleteIf(key, item []byte) (deleted bool) {
+	b.wlock.Lock(); defer b.wlock.Unlock()
+	err := b.DB.Update(func(txn *badger.Txn) error{
+		elem,err := txn.Get(key)
+		if err!=nil { return err }
+		v,err := elem.Value()
+		if err!=nil { return err }
+		if !bytes.Equal(v,item) { return errChanged }
+		return txn.Delete(key)
+	})
+	return err==nil
+}
 func (b *Badger) Stream(f func(key, item []byte)) {
 	b.DB.View(func(txn *badger.Txn) error{
 		iter := txn.NewIterator(badger.IteratorOptions{PrefetchValues:true,PrefetchSize:128})
@@ -99,3 +124,4 @@ func (b *Badger) Stream(f func(key, item []byte)) {
 }
 
 var _ api.StorageFacade = (*Badger)(nil)
+var _ api.CompareDeleter = (*Badger)(nil)
@@ -26,6 +26,7 @@ package boltdb
 import "github.com/byte-mug/brute/utils"
 import "github.com/byte-mug/brute/api"
 import bolt "github.com/coreos/bbolt"
+import "bytes"
 import "sync"
 
 var kvPairs = []byte("kvpairs")
@@ -34,13 +35,20 @@ type Bolt struct{
 	utils.MergeUtil
 	DB      *bolt.DB
 	
+	/* The bucket holding the key-item-pairs. If nil, "kvpairs" is used. */
+	Bucket  []byte
+	
 	wlock   sync.Mutex
 }
+func (b *Bolt) bucket() []byte {
+	if len(b.Bucket)==0 { return kvPairs }
+	return b.Bucket
+}
 
 func (b *Bolt) Submit(key, item []byte) (ok bool) {
 	b.wlock.Lock(); defer b.wlock.Unlock()
 	err := b.DB.Batch(func(txn *bolt.Tx) error{
-		bkt,err := txn.CreateBucketIfNotExists(kvPairs)
+		bkt,err := txn.CreateBucketIfNotExists(b.bucket())
 		if err!=nil { return err }
 		v := bkt.Get(key)
 		if len(v)==0 {
@@ -56,7 +64,7 @@ func (b *Bolt) Submit(key, item []byte) (ok bool) {
 }
 func (b *Bolt) Obtain(key []byte) (item []byte,ok,readable bool) {
 	b.DB.View(func(txn *bolt.Tx) error{
-		bkt := txn.Bucket(kvPairs)
+		bkt := txn.Bucket(b.bucket())
 		if bkt==nil { return nil }
 		item = bkt.Get(key)
 		readable = true
@@ -67,7 +75,7 @@ func (b *Bolt) Obtain(key []byte) (item []byte,ok,readable bool) {
 }
 func (b *Bolt) Stream(f func(key, item []byte)) {
 	b.DB.View(func(txn *bolt.Tx) error{
-		bkt := txn.Bucket(kvPairs)
+		bkt := txn.Bucket(b.bucket())
 		if bkt==nil { return nil }
 		c := bkt.Cursor()
 		for key,item := c.First(); len(key)!=0; key,item = c.Next() {
@@ -77,6 +85,31 @@ func (b *Bolt) Stream(f func(key, item []byte)) {
 	})
 }
 
+/* Removes a key-item-pair, eg. after its key moved to another node. */
+func (b *Bolt) Delete(key []byte) (ok bool) {
+	b.wlock.Lock(); defer b.wlock.Unlock()
+	err := b.DB.Update(func(txn *bolt.Tx) error{
+		bkt := txn.Bucket(b.bucket())
+		if bkt==nil { return nil }
+		return bkt.Delete(key)
+	})
+	return err==nil
+}
+
+/* Removes a key-item-pair, if the stored item equals 'item'. */
+func (b *Bolt) DeleteIf(key, item []byte) (deleted bool) {
+	b.wlock.Lock(); defer b.wlock.Unlock()
+	b.DB.Update(func(txn *bolt.Tx) error{
+		bkt := txn.Bucket(b.bucket())
+		if bkt==nil { return nil }
+		if v := bkt.Get(key); len(v)==0 || !bytes.Equal(v,item) { return nil }
+		if err := bkt.Delete(key); err!=nil { return err }
+		deleted = true
+		return nil
+	})
+	return
+}
+
 func (b *Bolt) StartBatch() api.StorageFacade {
 	tx,err := b.DB.Begin(true)
 	if err!=nil { return b }
@@ -84,6 +117,7 @@ func (b *Bolt) StartBatch() api.StorageFacade {
 }
 
 var _ api.StorageFacade = (*Bolt)(nil)
+var _ api.CompareDeleter = (*Bolt)(nil)
 
 type BoltBatch struct{
 	*Bolt
@@ -92,7 +126,7 @@ type BoltBatch struct{
 
 func (b *BoltBatch) Submit(key, item []byte) (ok bool) {
 	item = append(make([]byte,0,len(item)),item...)
-	bkt,err := b.Tx.CreateBucketIfNotExists(kvPairs)
+	bkt,err := b.Tx.CreateBucketIfNotExists(b.bucket())
 	if err!=nil { return }
 	ch := true
 	
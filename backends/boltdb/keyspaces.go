@@ -0,0 +1,110 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package boltdb
+
+import "github.com/byte-mug/brute/api"
+import bolt "github.com/coreos/bbolt"
+import "errors"
+import "sync"
+
+var ksPrefix = []byte("ks:")
+
+var ErrNoKeyspaceName = errors.New("boltdb: empty keyspace name")
+
+func ksBucket(name string) []byte {
+	return append(append(make([]byte,0,len(ksPrefix)+len(name)),ksPrefix...),name...)
+}
+
+/*
+Keyspaces hosts many named keyspaces inside a single Bolt file. Every keyspace
+lives in its own bucket and has its own MergerFactory, and is exposed as a
+separate StorageFacade.
+*/
+type Keyspaces struct{
+	DB      *bolt.DB
+	
+	lock    sync.Mutex
+	spaces  map[string]*Bolt
+}
+
+/*
+Opens the keyspace 'name', creating it if it does not exist.
+
+If the keyspace is already open, the existing StorageFacade is returned and
+'merger' is ignored.
+*/
+func (k *Keyspaces) Open(name string, merger api.MergerFactory) (*Bolt, error) {
+	if len(name)==0 { return nil,ErrNoKeyspaceName }
+	k.lock.Lock(); defer k.lock.Unlock()
+	if b,ok := k.spaces[name]; ok { return b,nil }
+	bn := ksBucket(name)
+	err := k.DB.Update(func(tx *bolt.Tx) error{
+		_,err := tx.CreateBucketIfNotExists(bn)
+		return err
+	})
+	if err!=nil { return nil,err }
+	b := &Bolt{DB:k.DB,Bucket:bn}
+	b.Merger = merger
+	if k.spaces==nil { k.spaces = make(map[string]*Bolt) }
+	k.spaces[name] = b
+	return b,nil
+}
+
+/* Returns the already opened keyspace 'name' or nil. */
+func (k *Keyspaces) Get(name string) *Bolt {
+	k.lock.Lock(); defer k.lock.Unlock()
+	return k.spaces[name]
+}
+
+/* Lists all keyspaces stored in the Bolt file, opened or not. */
+func (k *Keyspaces) List() (names []string, err error) {
+	err = k.DB.View(func(tx *bolt.Tx) error{
+		c := tx.Cursor()
+		for name,_ := c.Seek(ksPrefix); len(name)>len(ksPrefix); name,_ = c.Next() {
+			if string(name[:len(ksPrefix)])!=string(ksPrefix) { break }
+			names = append(names,string(name[len(ksPrefix):]))
+		}
+		return nil
+	})
+	return
+}
+
+/*
+Drops the keyspace 'name' and all of its data.
+
+StorageFacades previously returned by .Open() for this keyspace must not be
+used afterwards, as a .Submit() would recreate the keyspace.
+*/
+func (k *Keyspaces) Drop(name string) error {
+	if len(name)==0 { return ErrNoKeyspaceName }
+	k.lock.Lock(); defer k.lock.Unlock()
+	err := k.DB.Update(func(tx *bolt.Tx) error{
+		err := tx.DeleteBucket(ksBucket(name))
+		if err==bolt.ErrBucketNotFound { return nil }
+		return err
+	})
+	if err!=nil { return err }
+	delete(k.spaces,name)
+	return nil
+}
@@ -0,0 +1,499 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package gossip
+
+import "github.com/vmihailenco/msgpack"
+import "errors"
+import "math"
+import "math/rand"
+import "net"
+import "sort"
+import "sync"
+import "time"
+
+var (
+	ErrJoin    = errors.New("gossip: no seed answered")
+	ErrStarted = errors.New("gossip: node already started")
+)
+
+const (
+	DefaultProbeInterval  = time.Second
+	DefaultProbeTimeout   = 300*time.Millisecond
+	DefaultSuspectTimeout = 5*time.Second
+	DefaultIndirectProbes = 3
+)
+
+const (
+	mPing uint8 = iota
+	mAck
+	mPingReq
+	mJoin
+	mSync
+)
+
+/* The maximum number of updates, piggybacked on a message. */
+const maxPiggyback = 16
+
+type message struct{
+	Type    uint8
+	Seq     uint64
+	
+	/* mPing: the name of the target. mPingReq: the gossip address of the target. */
+	Target  string
+	Name    string
+	
+	Updates []update
+}
+
+type broadcast struct{
+	u     update
+	sent  int
+}
+
+/*
+A member of a cluster, that uses the SWIM protocol over UDP: every
+ProbeInterval, a member is pinged. If it does not acknowledge within
+ProbeTimeout, IndirectProbes other members are asked to ping it. If that
+fails too, the member is suspected, and declared dead, if it does not refute
+the suspicion within SuspectTimeout.
+
+Membership updates are disseminated by piggybacking them on the protocol
+messages.
+*/
+type Node struct{
+	Name string
+	
+	/* The address of the data endpoints, advertised to other members. */
+	Addr string
+	
+	/* The UDP address to listen on, eg. "127.0.0.1:0". */
+	Bind string
+	
+	/* Optional: the gossip address advertised. If "", the bound address is used. */
+	Advertise string
+	
+	ProbeInterval  time.Duration
+	ProbeTimeout   time.Duration
+	SuspectTimeout time.Duration
+	IndirectProbes int
+	
+	/* Optional: called after the state of a member changed. */
+	Notify func(m Member)
+	
+	lock     sync.Mutex
+	conn     *net.UDPConn
+	self     *Member
+	members  map[string]*Member
+	queue    []*broadcast
+	pending  map[uint64]func()
+	seq      uint64
+	probes   []string
+	stop     chan struct{}
+	done     sync.WaitGroup
+}
+func (n *Node) probeInterval() time.Duration {
+	if n.ProbeInterval<=0 { return DefaultProbeInterval }
+	return n.ProbeInterval
+}
+func (n *Node) probeTimeout() time.Duration {
+	if n.ProbeTimeout<=0 { return DefaultProbeTimeout }
+	return n.ProbeTimeout
+}
+func (n *Node) suspectTimeout() time.Duration {
+	if n.SuspectTimeout<=0 { return DefaultSuspectTimeout }
+	return n.SuspectTimeout
+}
+func (n *Node) indirectProbes() int {
+	if n.IndirectProbes<=0 { return DefaultIndirectProbes }
+	return n.IndirectProbes
+}
+
+/* Binds the UDP socket and starts the protocol. */
+func (n *Node) Start() error {
+	n.lock.Lock(); defer n.lock.Unlock()
+	if n.conn!=nil { return ErrStarted }
+	ua,err := net.ResolveUDPAddr("udp",n.Bind)
+	if err!=nil { return err }
+	conn,err := net.ListenUDP("udp",ua)
+	if err!=nil { return err }
+	adv := n.Advertise
+	if adv=="" { adv = conn.LocalAddr().String() }
+	n.conn = conn
+	n.self = &Member{Name:n.Name,Addr:n.Addr,Gossip:adv,State:Alive,Since:time.Now()}
+	n.members = map[string]*Member{n.Name:n.self}
+	n.pending = make(map[uint64]func())
+	n.stop = make(chan struct{})
+	n.enqueue(n.selfUpdate())
+	n.done.Add(2)
+	go n.receive()
+	go n.probeLoop()
+	return nil
+}
+
+/* Returns the advertised gossip address. */
+func (n *Node) GossipAddr() string {
+	n.lock.Lock(); defer n.lock.Unlock()
+	if n.self==nil { return "" }
+	return n.self.Gossip
+}
+
+/* Returns all known members, including itself and dead ones, sorted by name. */
+func (n *Node) Members() []Member {
+	n.lock.Lock(); defer n.lock.Unlock()
+	ms := make([]Member,0,len(n.members))
+	for _,m := range n.members { ms = append(ms,*m) }
+	sort.Slice(ms,func(i,j int) bool { return ms[i].Name<ms[j].Name })
+	return ms
+}
+
+/* Returns the live members (Alive or Suspect), including itself, sorted by name. */
+func (n *Node) Live() []Member {
+	ms := n.Members()
+	r := ms[:0]
+	for _,m := range ms {
+		if m.Live() { r = append(r,m) }
+	}
+	return r
+}
+
+/* Returns the data address of a live member. */
+func (n *Node) Lookup(name string) (addr string, ok bool) {
+	n.lock.Lock(); defer n.lock.Unlock()
+	m := n.members[name]
+	if m==nil || !m.Live() { return "",false }
+	return m.Addr,true
+}
+
+/* Contacts the seeds (gossip addresses) and waits, until one of them answered. */
+func (n *Node) Join(seeds ...string) error {
+	n.lock.Lock()
+	known := len(n.members)
+	n.lock.Unlock()
+	for _,s := range seeds {
+		n.send(s,&message{Type:mJoin,Updates:[]update{n.selfUpdate()}})
+	}
+	deadline := time.Now().Add(n.probeInterval()+n.probeTimeout())
+	for time.Now().Before(deadline) {
+		time.Sleep(n.probeTimeout()/10)
+		n.lock.Lock()
+		ok := len(n.members)>known
+		n.lock.Unlock()
+		if ok { return nil }
+	}
+	return ErrJoin
+}
+
+/*
+Announces, that this node leaves the cluster, to all live members, and stops
+the protocol.
+*/
+func (n *Node) Leave() error {
+	n.lock.Lock()
+	if n.self==nil { n.lock.Unlock(); return nil }
+	n.self.Incarnation++
+	n.self.State = Left
+	u := n.selfUpdate()
+	var addrs []string
+	for _,m := range n.members {
+		if m!=n.self && m.Live() { addrs = append(addrs,m.Gossip) }
+	}
+	n.lock.Unlock()
+	for _,a := range addrs {
+		n.send(a,&message{Type:mSync,Updates:[]update{u}})
+	}
+	return n.Close()
+}
+
+/* Stops the protocol without announcing it; the others will detect a failure. */
+func (n *Node) Close() error {
+	n.lock.Lock()
+	conn := n.conn
+	if conn==nil || n.stop==nil { n.lock.Unlock(); return nil }
+	close(n.stop)
+	n.stop = nil
+	n.lock.Unlock()
+	err := conn.Close()
+	n.done.Wait()
+	return err
+}
+
+/* Must be called with n.lock held. */
+func (n *Node) selfUpdate() update {
+	s := n.self
+	return update{s.Name,s.Addr,s.Gossip,s.State,s.Incarnation}
+}
+
+/* Queues an update for dissemination. Must be called with n.lock held. */
+func (n *Node) enqueue(u update) {
+	for _,b := range n.queue {
+		if b.u.Name==u.Name {
+			b.u,b.sent = u,0
+			return
+		}
+	}
+	n.queue = append(n.queue,&broadcast{u:u})
+}
+
+/*
+Picks the updates to piggyback, least sent first. Updates are sent about
+3*log2(N+1) times. Must be called with n.lock held.
+*/
+func (n *Node) piggyback() []update {
+	limit := 3*int(math.Ceil(math.Log2(float64(len(n.members)+1))))
+	sort.SliceStable(n.queue,func(i,j int) bool { return n.queue[i].sent<n.queue[j].sent })
+	var us []update
+	q := n.queue[:0]
+	for _,b := range n.queue {
+		if len(us)<maxPiggyback {
+			us = append(us,b.u)
+			b.sent++
+		}
+		if b.sent<limit { q = append(q,b) }
+	}
+	n.queue = q
+	return us
+}
+
+func (n *Node) send(addr string, msg *message) {
+	ua,err := net.ResolveUDPAddr("udp",addr)
+	if err!=nil { return }
+	n.sendUDP(ua,msg)
+}
+func (n *Node) sendUDP(ua *net.UDPAddr, msg *message) {
+	n.lock.Lock()
+	conn := n.conn
+	msg.Name = n.Name
+	if msg.Type!=mSync { msg.Updates = append(msg.Updates,n.piggyback()...) }
+	n.lock.Unlock()
+	if conn==nil { return }
+	data,err := msgpack.Marshal(msg)
+	if err!=nil { return }
+	conn.WriteToUDP(data,ua)
+}
+
+/*
+Applies updates and returns the members, that changed. Must be called with
+n.lock held.
+*/
+func (n *Node) apply(us []update) (changed []Member) {
+	for _,u := range us {
+		if u.Name==n.Name {
+			/* Refute suspicion (or an outdated death) with a newer incarnation. */
+			if n.self.State==Alive && u.State!=Alive && u.Inc>=n.self.Incarnation {
+				n.self.Incarnation = u.Inc+1
+				n.enqueue(n.selfUpdate())
+			}
+			continue
+		}
+		m := n.members[u.Name]
+		if m==nil {
+			if u.State==Dead || u.State==Left { continue }
+			m = &Member{Name:u.Name}
+			n.members[u.Name] = m
+		} else if !u.overrides(m) {
+			continue
+		}
+		old := m.State
+		m.Addr,m.Gossip,m.Incarnation = u.Addr,u.Gossip,u.Inc
+		m.State = u.State
+		if old!=m.State || m.Since.IsZero() {
+			m.Since = time.Now()
+			changed = append(changed,*m)
+		}
+		n.enqueue(u)
+	}
+	return
+}
+func (n *Node) notify(ms []Member) {
+	if n.Notify==nil { return }
+	for _,m := range ms { n.Notify(m) }
+}
+
+func (n *Node) receive() {
+	defer n.done.Done()
+	buf := make([]byte,65536)
+	for {
+		l,from,err := n.conn.ReadFromUDP(buf)
+		if err!=nil {
+			select {
+			case <-n.stopped(): return
+			default:
+			}
+			if ne,ok := err.(net.Error); ok && ne.Temporary() { continue }
+			return
+		}
+		var msg message
+		if msgpack.Unmarshal(buf[:l],&msg)!=nil { continue }
+		n.handle(&msg,from)
+	}
+}
+func (n *Node) stopped() <-chan struct{} {
+	n.lock.Lock(); defer n.lock.Unlock()
+	if n.stop==nil {
+		c := make(chan struct{})
+		close(c)
+		return c
+	}
+	return n.stop
+}
+func (n *Node) handle(msg *message, from *net.UDPAddr) {
+	n.lock.Lock()
+	changed := n.apply(msg.Updates)
+	var reply *message
+	var ack func()
+	switch msg.Type {
+	case mPing:
+		if msg.Target==n.Name { reply = &message{Type:mAck,Seq:msg.Seq} }
+	case mAck:
+		ack = n.pending[msg.Seq]
+		delete(n.pending,msg.Seq)
+	case mJoin:
+		reply = &message{Type:mSync}
+		for _,m := range n.members {
+			reply.Updates = append(reply.Updates,update{m.Name,m.Addr,m.Gossip,m.State,m.Incarnation})
+		}
+	}
+	n.lock.Unlock()
+	n.notify(changed)
+	if ack!=nil { ack() }
+	if reply!=nil { n.sendUDP(from,reply) }
+	if msg.Type==mPingReq { go n.relay(msg,from) }
+}
+
+/* Pings the target of a mPingReq and forwards the ack to the requester. */
+func (n *Node) relay(req *message, from *net.UDPAddr) {
+	ua,err := net.ResolveUDPAddr("udp",req.Target)
+	if err!=nil { return }
+	seq := n.expect(func(){ n.sendUDP(from,&message{Type:mAck,Seq:req.Seq}) })
+	time.AfterFunc(n.probeInterval(),func(){ n.forget(seq) })
+	n.lock.Lock()
+	var name string
+	for _,m := range n.members {
+		if m.Gossip==req.Target { name = m.Name }
+	}
+	n.lock.Unlock()
+	n.sendUDP(ua,&message{Type:mPing,Seq:seq,Target:name})
+}
+
+func (n *Node) expect(f func()) uint64 {
+	n.lock.Lock(); defer n.lock.Unlock()
+	n.seq++
+	n.pending[n.seq] = f
+	return n.seq
+}
+func (n *Node) forget(seq uint64) {
+	n.lock.Lock(); defer n.lock.Unlock()
+	delete(n.pending,seq)
+}
+
+func (n *Node) probeLoop() {
+	defer n.done.Done()
+	stop := n.stopped()
+	t := time.NewTicker(n.probeInterval())
+	defer t.Stop()
+	for {
+		select {
+		case <-stop: return
+		case <-t.C:
+		}
+		n.probe(stop)
+		n.reap()
+	}
+}
+
+/* Returns the next member to probe, round-robin in random order. */
+func (n *Node) next() *Member {
+	n.lock.Lock(); defer n.lock.Unlock()
+	for len(n.probes)>0 {
+		name := n.probes[0]
+		n.probes = n.probes[1:]
+		if m := n.members[name]; m!=nil && m.Live() { c := *m; return &c }
+	}
+	for name,m := range n.members {
+		if m!=n.self && m.Live() { n.probes = append(n.probes,name) }
+	}
+	if len(n.probes)==0 { return nil }
+	rand.Shuffle(len(n.probes),func(i,j int) { n.probes[i],n.probes[j] = n.probes[j],n.probes[i] })
+	m := *n.members[n.probes[0]]
+	n.probes = n.probes[1:]
+	return &m
+}
+
+/* Returns up to k random live members, other than itself and 'not'. */
+func (n *Node) random(k int, not string) (addrs []string) {
+	n.lock.Lock(); defer n.lock.Unlock()
+	for _,m := range n.members {
+		if m!=n.self && m.Name!=not && m.State==Alive { addrs = append(addrs,m.Gossip) }
+	}
+	rand.Shuffle(len(addrs),func(i,j int) { addrs[i],addrs[j] = addrs[j],addrs[i] })
+	if len(addrs)>k { addrs = addrs[:k] }
+	return
+}
+
+func (n *Node) probe(stop <-chan struct{}) {
+	m := n.next()
+	if m==nil { return }
+	acked := make(chan struct{},1)
+	seq := n.expect(func(){
+		select {
+		case acked <- struct{}{}:
+		default:
+		}
+	})
+	defer n.forget(seq)
+	n.send(m.Gossip,&message{Type:mPing,Seq:seq,Target:m.Name})
+	select {
+	case <-acked: return
+	case <-stop: return
+	case <-time.After(n.probeTimeout()):
+	}
+	for _,a := range n.random(n.indirectProbes(),m.Name) {
+		n.send(a,&message{Type:mPingReq,Seq:seq,Target:m.Gossip})
+	}
+	wait := n.probeInterval()-n.probeTimeout()
+	if wait<n.probeTimeout() { wait = n.probeTimeout() }
+	select {
+	case <-acked: return
+	case <-stop: return
+	case <-time.After(wait):
+	}
+	n.lock.Lock()
+	changed := n.apply([]update{{m.Name,m.Addr,m.Gossip,Suspect,m.Incarnation}})
+	n.lock.Unlock()
+	n.notify(changed)
+}
+
+/* Declares members dead, that were suspected for longer than SuspectTimeout. */
+func (n *Node) reap() {
+	n.lock.Lock()
+	var us []update
+	for _,m := range n.members {
+		if m.State==Suspect && time.Since(m.Since)>n.suspectTimeout() {
+			us = append(us,update{m.Name,m.Addr,m.Gossip,Dead,m.Incarnation})
+		}
+	}
+	changed := n.apply(us)
+	n.lock.Unlock()
+	n.notify(changed)
+}
@@ -0,0 +1,107 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package gossip
+
+import "github.com/byte-mug/brute/cluster/ring"
+import "fmt"
+import "testing"
+import "time"
+
+func newNode(name string) *Node {
+	return &Node{
+		Name: name,
+		Addr: "data-"+name,
+		Bind: "127.0.0.1:0",
+		ProbeInterval: 50*time.Millisecond,
+		ProbeTimeout: 15*time.Millisecond,
+		SuspectTimeout: 300*time.Millisecond,
+	}
+}
+
+func waitFor(t *testing.T, what string, f func() bool) {
+	for deadline := time.Now().Add(10*time.Second); time.Now().Before(deadline); time.Sleep(20*time.Millisecond) {
+		if f() { return }
+	}
+	t.Fatal("timeout waiting for: "+what)
+}
+
+/* Returns true, if every running node sees exactly n live members. */
+func allSee(nodes []*Node, n int) func() bool {
+	return func() bool {
+		for _,node := range nodes {
+			if node!=nil && len(node.Live())!=n { return false }
+		}
+		return true
+	}
+}
+
+func TestCluster(t *testing.T) {
+	const N = 12
+	r := &ring.Ring{}
+	nodes := make([]*Node,N)
+	for i := range nodes {
+		n := newNode(fmt.Sprint("n",i))
+		if i==0 { n.Notify = func(Member){ SyncRing(r,n.Live()) } }
+		if err := n.Start(); err!=nil { t.Fatal(err) }
+		if i>0 {
+			if err := n.Join(nodes[0].GossipAddr()); err!=nil { t.Fatal(err) }
+		}
+		nodes[i] = n
+	}
+	defer func(){
+		for _,n := range nodes {
+			if n!=nil { n.Close() }
+		}
+	}()
+	waitFor(t,"convergence",allSee(nodes,N))
+	if addr,ok := nodes[1].Lookup("n7"); !ok || addr!="data-n7" { t.Fatalf("lookup: %q %v",addr,ok) }
+	
+	/* A crashed node is suspected and declared dead. */
+	nodes[3].Close()
+	nodes[3] = nil
+	waitFor(t,"failure detection",allSee(nodes,N-1))
+	
+	/* A leaving node is removed at once. */
+	nodes[5].Leave()
+	nodes[5] = nil
+	waitFor(t,"leave",allSee(nodes,N-2))
+	for _,m := range nodes[0].Members() {
+		if (m.Name=="n3" && m.State!=Dead) || (m.Name=="n5" && m.State!=Left) { t.Fatalf("%s is %v",m.Name,m.State) }
+	}
+	waitFor(t,"ring update",func() bool { return len(r.Nodes())==N-2 && !r.Has("n3") && !r.Has("n5") })
+	
+	/* A restarted node refutes its death with a newer incarnation. */
+	n3 := newNode("n3")
+	if err := n3.Start(); err!=nil { t.Fatal(err) }
+	nodes[3] = n3
+	if err := n3.Join(nodes[0].GossipAddr()); err!=nil { t.Fatal(err) }
+	waitFor(t,"rejoin",allSee(nodes,N-1))
+}
+
+func TestJoinFails(t *testing.T) {
+	n := newNode("lonely")
+	if err := n.Start(); err!=nil { t.Fatal(err) }
+	defer n.Close()
+	if err := n.Join("127.0.0.1:1"); err!=ErrJoin { t.Fatalf("got %v",err) }
+}
@@ -0,0 +1,109 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package gossip
+
+import "time"
+
+type State uint8
+
+const (
+	Alive State = iota
+	Suspect
+	Dead
+	
+	/* The member left the cluster voluntarily. */
+	Left
+)
+
+func (s State) String() string {
+	switch s {
+	case Alive: return "alive"
+	case Suspect: return "suspect"
+	case Dead: return "dead"
+	case Left: return "left"
+	}
+	return "unknown"
+}
+
+type Member struct{
+	Name        string
+	
+	/* The address of the data endpoints, eg. "host:port" of the HTTP server. */
+	Addr        string
+	
+	/* The UDP address of the gossip endpoint. */
+	Gossip      string
+	
+	State       State
+	
+	/*
+	Only the member itself increments its incarnation, to refute being
+	suspected. Newer incarnations override older ones.
+	*/
+	Incarnation uint64
+	
+	/* When the State last changed. */
+	Since       time.Time
+}
+
+/* Returns true, if the member is considered part of the cluster (Alive or Suspect). */
+func (m *Member) Live() bool {
+	return m.State==Alive || m.State==Suspect
+}
+
+/* A membership update, piggybacked on the protocol messages. */
+type update struct{
+	Name   string
+	Addr   string
+	Gossip string
+	State  State
+	Inc    uint64
+}
+
+/*
+Returns true, if u overrides the current state of a member, following the
+SWIM rules:
+
+	Alive   overrides, if its incarnation is newer.
+	Suspect overrides Alive of the same or an older incarnation, and Suspect
+	        of an older one.
+	Dead and Left override all but Dead and Left of the same or an older
+	        incarnation.
+*/
+func (u *update) overrides(m *Member) bool {
+	switch u.State {
+	case Alive:
+		return u.Inc>m.Incarnation
+	case Suspect:
+		switch m.State {
+		case Alive: return u.Inc>=m.Incarnation
+		case Suspect: return u.Inc>m.Incarnation
+		}
+		return u.Inc>m.Incarnation
+	case Dead,Left:
+		if m.State==Dead || m.State==Left { return u.Inc>m.Incarnation }
+		return u.Inc>=m.Incarnation
+	}
+	return false
+}
@@ -0,0 +1,66 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package gossip
+
+import "github.com/byte-mug/brute/cluster/ring"
+
+/*
+Makes the nodes of r match the live members: live members are added, others
+removed. Meant to be called from Node.Notify, eg.
+
+	n.Notify = func(gossip.Member){ gossip.SyncRing(r,n.Live()) }
+
+See also ReplayHints.
+*/
+func SyncRing(r *ring.Ring, members []Member) {
+	live := make(map[string]bool,len(members))
+	var add []string
+	for _,m := range members {
+		if !m.Live() { continue }
+		live[m.Name] = true
+		if !r.Has(m.Name) { add = append(add,m.Name) }
+	}
+	var del []string
+	for _,name := range r.Nodes() {
+		if !live[name] { del = append(del,name) }
+	}
+	if len(add)>0 { r.Add(add...) }
+	if len(del)>0 { r.Remove(del...) }
+}
+
+/*
+Delivers the hints of rt, once member m is Alive again, eg. after it was
+suspected or rejoined. Meant to be called from Node.Notify, eg.
+
+	n.Notify = func(m gossip.Member){
+		gossip.SyncRing(rt.Ring,n.Live())
+		gossip.ReplayHints(rt,m)
+	}
+
+The hints are delivered in the background, as Notify must not block.
+*/
+func ReplayHints(rt *ring.Router, m Member) {
+	if rt.Hints==nil || m.State!=Alive { return }
+	go rt.Replay()
+}
@@ -0,0 +1,218 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package handoff
+
+import "github.com/byte-mug/brute/metrics"
+import "github.com/byte-mug/brute/api"
+import bolt "github.com/coreos/bbolt"
+import "github.com/vmihailenco/msgpack"
+import "encoding/binary"
+import "sync"
+import "time"
+
+var hintPrefix = []byte("hints:")
+
+/* The default value for Hints.MaxAge. */
+const DefaultMaxAge = 24*time.Hour
+
+/* The number of hints, delivered per transaction. */
+const replayBatch = 256
+
+func hintBucket(node string) []byte {
+	return append(append(make([]byte,0,len(hintPrefix)+len(node)),hintPrefix...),node...)
+}
+
+type hint struct{
+	id   []byte
+	key  []byte
+	item []byte
+}
+
+/*
+A durable queue of hints: writes, that could not be delivered to their
+replica. The hints of every node are stored in their own Bolt bucket, in the
+order they were stored, and are replayed, once the node is back.
+
+Hints, that could not be delivered within MaxAge, are dropped; the replica
+has to catch up using a full sync.
+*/
+type Hints struct{
+	DB      *bolt.DB
+	
+	/* If 0, DefaultMaxAge is used. */
+	MaxAge  time.Duration
+	
+	/* Optional: if set, hint counts are recorded, labeled by node. */
+	Metrics *metrics.Registry
+	
+	/* Serializes replays. */
+	replay  sync.Mutex
+}
+func (h *Hints) maxAge() time.Duration {
+	if h.MaxAge<=0 { return DefaultMaxAge }
+	return h.MaxAge
+}
+func (h *Hints) count(name, help, node string, n int) {
+	if h.Metrics==nil || n==0 { return }
+	h.Metrics.Counter(name,help,"node",node).Add(uint64(n))
+}
+
+/* Stores a hint for node. */
+func (h *Hints) Store(node string, key, item []byte) bool {
+	data,err := msgpack.Marshal([][]byte{key,item})
+	if err!=nil { return false }
+	err = h.DB.Batch(func(tx *bolt.Tx) error{
+		bkt,err := tx.CreateBucketIfNotExists(hintBucket(node))
+		if err!=nil { return err }
+		seq,err := bkt.NextSequence()
+		if err!=nil { return err }
+		id := make([]byte,16)
+		binary.BigEndian.PutUint64(id,uint64(time.Now().UnixNano()))
+		binary.BigEndian.PutUint64(id[8:],seq)
+		return bkt.Put(id,data)
+	})
+	if err!=nil { return false }
+	h.count("brute_hints_stored_total","Number of hints stored.",node,1)
+	return true
+}
+
+/* Returns the nodes, hints are stored for. */
+func (h *Hints) Nodes() (nodes []string, err error) {
+	err = h.DB.View(func(tx *bolt.Tx) error{
+		c := tx.Cursor()
+		for name,_ := c.Seek(hintPrefix); len(name)>len(hintPrefix); name,_ = c.Next() {
+			if string(name[:len(hintPrefix)])!=string(hintPrefix) { break }
+			if id,_ := tx.Bucket(name).Cursor().First(); id==nil { continue }
+			nodes = append(nodes,string(name[len(hintPrefix):]))
+		}
+		return nil
+	})
+	return
+}
+
+/* Returns the number of hints stored for node. */
+func (h *Hints) Pending(node string) (n int) {
+	h.DB.View(func(tx *bolt.Tx) error{
+		if bkt := tx.Bucket(hintBucket(node)); bkt!=nil { n = bkt.Stats().KeyN }
+		return nil
+	})
+	return
+}
+
+/* Reads up to replayBatch hints, skipping and collecting expired ones. */
+func (h *Hints) next(node string) (hints []hint, expired [][]byte, err error) {
+	limit := uint64(time.Now().Add(-h.maxAge()).UnixNano())
+	err = h.DB.View(func(tx *bolt.Tx) error{
+		bkt := tx.Bucket(hintBucket(node))
+		if bkt==nil { return nil }
+		c := bkt.Cursor()
+		for id,data := c.First(); len(id)!=0 && len(hints)<replayBatch; id,data = c.Next() {
+			id = append([]byte(nil),id...)
+			var kv [][]byte
+			if len(id)!=16 || binary.BigEndian.Uint64(id)<limit || msgpack.Unmarshal(data,&kv)!=nil || len(kv)!=2 {
+				expired = append(expired,id)
+				continue
+			}
+			hints = append(hints,hint{id,kv[0],kv[1]})
+		}
+		return nil
+	})
+	return
+}
+func (h *Hints) remove(node string, ids [][]byte) error {
+	if len(ids)==0 { return nil }
+	return h.DB.Update(func(tx *bolt.Tx) error{
+		bkt := tx.Bucket(hintBucket(node))
+		if bkt==nil { return nil }
+		for _,id := range ids {
+			if err := bkt.Delete(id); err!=nil { return err }
+		}
+		return nil
+	})
+}
+
+/*
+Delivers the hints stored for node to target, oldest first, and removes them.
+Stops at the first hint, target does not accept (as the node is likely down
+again); the remaining hints are kept.
+
+Returns the number of hints delivered.
+*/
+func (h *Hints) Replay(node string, target api.StorageFacade) (delivered int, err error) {
+	h.replay.Lock(); defer h.replay.Unlock()
+	for {
+		hints,expired,err := h.next(node)
+		if err!=nil { return delivered,err }
+		if err = h.remove(node,expired); err!=nil { return delivered,err }
+		h.count("brute_hints_expired_total","Number of hints dropped, as they expired.",node,len(expired))
+		if len(hints)==0 && len(expired)==0 { return delivered,nil }
+		
+		done := make([][]byte,0,len(hints))
+		for _,ht := range hints {
+			if !target.Submit(ht.key,ht.item) { break }
+			done = append(done,ht.id)
+		}
+		err = h.remove(node,done)
+		delivered += len(done)
+		h.count("brute_hints_delivered_total","Number of hints delivered.",node,len(done))
+		if err!=nil || len(done)<len(hints) { return delivered,err }
+	}
+}
+
+/*
+Replays the hints of all nodes, resolve returns available. Meant to be called
+periodically, or when a node came back.
+*/
+func (h *Hints) ReplayAll(resolve func(node string) api.StorageFacade) (delivered int, err error) {
+	nodes,err := h.Nodes()
+	if err!=nil { return }
+	for _,node := range nodes {
+		s := resolve(node)
+		if s==nil { continue }
+		n,e := h.Replay(node,s)
+		delivered += n
+		if e!=nil { err = e }
+	}
+	return
+}
+
+/*
+Wraps the StorageFacade of node, so that failed Submits are stored as hints.
+The Submit still reports the failure, so hints do not count towards a write
+quorum.
+*/
+func (h *Hints) Wrap(node string, s api.StorageFacade) api.StorageFacade {
+	return &hinted{s,h,node}
+}
+
+type hinted struct{
+	api.StorageFacade
+	hints *Hints
+	node  string
+}
+func (h *hinted) Submit(key, item []byte) bool {
+	if h.StorageFacade.Submit(key,item) { return true }
+	h.hints.Store(h.node,key,item)
+	return false
+}
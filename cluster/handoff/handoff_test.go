@@ -0,0 +1,145 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package handoff
+
+import "github.com/byte-mug/brute/datatypes"
+import "github.com/byte-mug/brute/metrics"
+import "github.com/byte-mug/brute/utils"
+import "github.com/byte-mug/brute/api"
+import bolt "github.com/coreos/bbolt"
+import "bytes"
+import "fmt"
+import "path/filepath"
+import "sort"
+import "strings"
+import "sync"
+import "testing"
+import "time"
+
+/* An in-memory StorageFacade, that accepts 'accept' Submits (if >=0) and fails afterwards. */
+type mem struct{
+	utils.MergeUtil
+	lock   sync.Mutex
+	m      map[string][]byte
+	order  []string
+	accept int
+}
+func newMem(f api.MergerFactory) *mem {
+	m := &mem{m:make(map[string][]byte),accept:-1}
+	m.Merger = f
+	return m
+}
+func (m *mem) Submit(key, item []byte) bool {
+	m.lock.Lock(); defer m.lock.Unlock()
+	if m.accept==0 { return false }
+	if m.accept>0 { m.accept-- }
+	if o,ok := m.m[string(key)]; ok { item,_ = m.Merge(o,item) }
+	m.m[string(key)] = item
+	m.order = append(m.order,string(key))
+	return true
+}
+func (m *mem) Obtain(key []byte) ([]byte,bool,bool) {
+	m.lock.Lock(); defer m.lock.Unlock()
+	item,ok := m.m[string(key)]
+	return item,ok,true
+}
+func (m *mem) Stream(f func(key, item []byte)) {
+	m.lock.Lock()
+	keys := make([]string,0,len(m.m))
+	for k := range m.m { keys = append(keys,k) }
+	m.lock.Unlock()
+	sort.Strings(keys)
+	for _,k := range keys {
+		item,_,_ := m.Obtain([]byte(k))
+		f([]byte(k),item)
+	}
+}
+
+func newHints(t *testing.T) *Hints {
+	db,err := bolt.Open(filepath.Join(t.TempDir(),"hints.db"),0600,nil)
+	if err!=nil { t.Fatal(err) }
+	t.Cleanup(func(){ db.Close() })
+	return &Hints{DB:db,Metrics:&metrics.Registry{}}
+}
+
+func TestReplay(t *testing.T) {
+	h := newHints(t)
+	m := newMem(datatypes.LWW_Factory)
+	m.accept = 0
+	w := h.Wrap("n1",m)
+	n := replayBatch*2+10
+	for i := 0; i<n; i++ {
+		if w.Submit([]byte(fmt.Sprintf("%04d",i)),datatypes.LWW_Put([]byte("v"))) { t.Fatal("a failed Submit reported success") }
+	}
+	if p := h.Pending("n1"); p!=n { t.Fatalf("%d hints pending, want %d",p,n) }
+	resolve := func(string) api.StorageFacade { return m }
+	if d,err := h.ReplayAll(resolve); d!=0 || err!=nil { t.Fatalf("replay to a failing node: %d %v",d,err) }
+	if p := h.Pending("n1"); p!=n { t.Fatalf("%d hints pending, want %d",p,n) }
+	
+	m.accept = -1
+	if d,err := h.ReplayAll(resolve); d!=n || err!=nil { t.Fatalf("replayed %d %v, want %d",d,err,n) }
+	if !sort.StringsAreSorted(m.order) || len(m.order)!=n { t.Fatal("hints were not delivered oldest first") }
+	if p := h.Pending("n1"); p!=0 { t.Fatalf("%d hints pending after the replay",p) }
+	if nodes,_ := h.Nodes(); len(nodes)!=0 { t.Fatalf("nodes %v after the replay",nodes) }
+	
+	var buf bytes.Buffer
+	h.Metrics.WriteTo(&buf)
+	for _,l := range []string{
+		fmt.Sprintf(`brute_hints_stored_total{node="n1"} %d`,n),
+		fmt.Sprintf(`brute_hints_delivered_total{node="n1"} %d`,n),
+	} {
+		if !strings.Contains(buf.String(),l+"\n") { t.Errorf("missing %q in:\n%s",l,buf.String()) }
+	}
+}
+
+/* A replay, that is cut short, resumes where it stopped; stale hints do not override newer writes. */
+func TestReplayPartial(t *testing.T) {
+	h := newHints(t)
+	m := newMem(datatypes.LWW_Factory)
+	for i := 0; i<10; i++ { h.Store("n1",[]byte(fmt.Sprint(i)),datatypes.LWW_Put([]byte("old"))) }
+	time.Sleep(time.Millisecond)
+	m.Submit([]byte("0"),datatypes.LWW_Put([]byte("new")))
+	
+	m.accept = 4
+	if d,_ := h.Replay("n1",m); d!=4 { t.Fatalf("delivered %d, want 4",d) }
+	if p := h.Pending("n1"); p!=6 { t.Fatalf("%d hints pending, want 6",p) }
+	m.accept = -1
+	if d,_ := h.Replay("n1",m); d!=6 { t.Fatalf("delivered %d, want 6",d) }
+	
+	for i := 0; i<10; i++ {
+		want := "old"
+		if i==0 { want = "new" }
+		if v,_ := datatypes.LWW_Decode(m.Obtain([]byte(fmt.Sprint(i)))); string(v)!=want { t.Errorf("key %d: got %q, want %q",i,v,want) }
+	}
+}
+
+func TestExpire(t *testing.T) {
+	h := newHints(t)
+	m := newMem(datatypes.LWW_Factory)
+	h.Store("n1",[]byte("k"),datatypes.LWW_Put([]byte("v")))
+	h.MaxAge = time.Nanosecond
+	time.Sleep(time.Millisecond)
+	if d,_ := h.Replay("n1",m); d!=0 || h.Pending("n1")!=0 { t.Fatal("an expired hint was not dropped") }
+	if _,ok,_ := m.Obtain([]byte("k")); ok { t.Fatal("an expired hint was delivered") }
+}
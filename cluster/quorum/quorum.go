@@ -0,0 +1,148 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package quorum
+
+import "github.com/byte-mug/brute/utils"
+import "github.com/byte-mug/brute/api"
+
+type reply struct{
+	idx      int
+	item     []byte
+	ok       bool
+	readable bool
+}
+
+/*
+A StorageFacade, that spreads reads and writes across N replicas (usually
+*httpi.Client instances), according to the Dynamo quorum model.
+
+.Obtain() asks all replicas and merges the first R readable replies.
+.Submit() sends the item to all replicas and succeeds as soon as W of them
+accepted it. As R+W>N guarantees, that every read sees at least one replica
+with the latest acknowledged write, reads are fresh.
+
+Replicas, that reply late, are not waited for; their requests are finished in
+the background.
+*/
+type Quorum struct{
+	utils.MergeUtil
+	Replicas []api.StorageFacade
+	
+	/* The read and write quorums. If 0, a majority of the replicas is used. */
+	R, W     int
+	
+	/*
+	Read repair: the probability (0 to 1), that a read checks the replies for
+	stale replicas, and asynchronously submits the merged item to them. If 0,
+	read repair is disabled.
+	*/
+	RepairChance float64
+	
+	/* Optional: the maximum number of repairs per second. If 0, unlimited. */
+	RepairRate   float64
+	
+	repairs  repairs
+}
+func quorum(q, n int) int {
+	if q<=0 || q>n { return n/2+1 }
+	return q
+}
+
+func (q *Quorum) Submit(key, item []byte) bool {
+	return q.SubmitTo(q.Replicas,key,item)
+}
+
+/* Like .Submit(), but uses the given replicas instead of q.Replicas. */
+func (q *Quorum) SubmitTo(replicas []api.StorageFacade, key, item []byte) bool {
+	n := len(replicas)
+	if n==0 { return false }
+	w := quorum(q.W,n)
+	ch := make(chan bool,n)
+	for _,r := range replicas {
+		go func(r api.StorageFacade){ ch <- r.Submit(key,item) }(r)
+	}
+	acks,fails := 0,0
+	for acks<w && fails<=n-w {
+		if <-ch { acks++ } else { fails++ }
+	}
+	return acks>=w
+}
+
+/*
+Asks all replicas and waits until R of them replied readable or the quorum
+can no longer be reached. Returns the readable replies.
+*/
+func (q *Quorum) gather(replicas []api.StorageFacade, key []byte) (replies []reply,met bool) {
+	n := len(replicas)
+	if n==0 { return }
+	r := quorum(q.R,n)
+	ch := make(chan reply,n)
+	for i,s := range replicas {
+		go func(i int,s api.StorageFacade){
+			item,ok,readable := s.Obtain(key)
+			ch <- reply{i,item,ok,readable}
+		}(i,s)
+	}
+	fails := 0
+	for len(replies)<r && fails<=n-r {
+		rp := <-ch
+		if rp.readable { replies = append(replies,rp) } else { fails++ }
+	}
+	met = len(replies)>=r
+	return
+}
+
+func (q *Quorum) Obtain(key []byte) (item []byte,ok,readable bool) {
+	return q.ObtainFrom(q.Replicas,key)
+}
+
+/* Like .Obtain(), but uses the given replicas instead of q.Replicas. */
+func (q *Quorum) ObtainFrom(replicas []api.StorageFacade, key []byte) (item []byte,ok,readable bool) {
+	replies,met := q.gather(replicas,key)
+	if !met { return }
+	readable = true
+	items := make([][]byte,0,len(replies))
+	for _,rp := range replies {
+		if rp.ok { items = append(items,rp.item) }
+	}
+	switch len(items) {
+	case 0: return
+	case 1: item = items[0]
+	default: item,_ = q.Merge(items...)
+	}
+	ok = true
+	if q.RepairChance>0 { q.repair(replicas,replies,key,item) }
+	return
+}
+
+/*
+Streams the first replica. Note, that this is not a quorum read: the stream
+reflects the state of that single replica.
+*/
+func (q *Quorum) Stream(f func(key, item []byte)) {
+	if len(q.Replicas)==0 { return }
+	q.Replicas[0].Stream(f)
+}
+
+var _ api.StorageFacade = (*Quorum)(nil)
@@ -0,0 +1,168 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package quorum
+
+import "github.com/byte-mug/brute/datatypes"
+import "github.com/byte-mug/brute/utils"
+import "github.com/byte-mug/brute/api"
+import "fmt"
+import "sort"
+import "sync"
+import "testing"
+import "time"
+
+/* An in-memory StorageFacade, that can be made to fail. */
+type mem struct{
+	utils.MergeUtil
+	lock sync.Mutex
+	m    map[string][]byte
+	fail bool
+}
+func newMem(f api.MergerFactory) *mem {
+	m := &mem{m:make(map[string][]byte)}
+	m.Merger = f
+	return m
+}
+func (m *mem) Submit(key, item []byte) bool {
+	m.lock.Lock(); defer m.lock.Unlock()
+	if m.fail { return false }
+	if o,ok := m.m[string(key)]; ok { item,_ = m.Merge(o,item) }
+	m.m[string(key)] = item
+	return true
+}
+func (m *mem) Obtain(key []byte) ([]byte,bool,bool) {
+	m.lock.Lock(); defer m.lock.Unlock()
+	if m.fail { return nil,false,false }
+	item,ok := m.m[string(key)]
+	return item,ok,true
+}
+func (m *mem) Stream(f func(key, item []byte)) {
+	m.lock.Lock()
+	keys := make([]string,0,len(m.m))
+	for k := range m.m { keys = append(keys,k) }
+	m.lock.Unlock()
+	sort.Strings(keys)
+	for _,k := range keys {
+		item,_,_ := m.Obtain([]byte(k))
+		f([]byte(k),item)
+	}
+}
+func (m *mem) setFail(fail bool) {
+	m.lock.Lock(); defer m.lock.Unlock()
+	m.fail = fail
+}
+
+func newQuorum(f api.MergerFactory, n int) (*Quorum,[]*mem) {
+	q := &Quorum{}
+	q.Merger = f
+	ms := make([]*mem,n)
+	for i := range ms {
+		ms[i] = newMem(f)
+		q.Replicas = append(q.Replicas,ms[i])
+	}
+	return q,ms
+}
+
+/* Waits for the asynchronous repairs to be accepted. */
+func waitRepairs(t *testing.T, q *Quorum, accepted uint64) {
+	deadline := time.Now().Add(5*time.Second)
+	for q.RepairStats().Accepted<accepted {
+		if time.Now().After(deadline) { t.Fatalf("repairs: %+v, want %d accepted",q.RepairStats(),accepted) }
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestQuorum(t *testing.T) {
+	q,ms := newQuorum(datatypes.LWW_Factory,3)
+	key := []byte("k")
+	ms[2].setFail(true)
+	if !q.Submit(key,datatypes.LWW_Put([]byte("v1"))) { t.Fatal("a write to 2 of 3 replicas failed") }
+	time.Sleep(time.Millisecond)
+	ms[0].Submit(key,datatypes.LWW_Put([]byte("v2")))
+	if v,ok := datatypes.LWW_Decode(q.Obtain(key)); !ok || string(v)!="v2" { t.Fatalf("got %q %v, want v2",v,ok) }
+	
+	ms[1].setFail(true)
+	if q.Submit(key,datatypes.LWW_Put([]byte("v3"))) { t.Fatal("a write to 1 of 3 replicas succeeded") }
+	if _,_,readable := q.Obtain(key); readable { t.Fatal("a read from 1 of 3 replicas succeeded") }
+}
+
+/* Whatever the order of the replies, the read yields the same item and repairs all replicas to it. */
+func TestRepairOrder(t *testing.T) {
+	key := []byte("k")
+	var parts [][]byte
+	for i := 0; i<3; i++ {
+		row := datatypes.Row{}
+		for c := 0; c<4; c++ { row[fmt.Sprint("c",i,c)] = i }
+		parts = append(parts,datatypes.Table_Put(row))
+	}
+	var want []byte
+	for _,perm := range [][]int{{0,1,2},{1,2,0},{2,0,1},{2,1,0}} {
+		q,ms := newQuorum(datatypes.Table_Factory,3)
+		q.R = 3
+		q.RepairChance = 1
+		for i,p := range perm { ms[i].Submit(key,parts[p]) }
+		item,ok,_ := q.Obtain(key)
+		if !ok { t.Fatal("not found") }
+		if want==nil { want = item }
+		if string(item)!=string(want) { t.Fatalf("order %v: got %x, want %x",perm,item,want) }
+		
+		waitRepairs(t,q,3)
+		for i,m := range ms {
+			if got,_,_ := m.Obtain(key); string(got)!=string(want) { t.Fatalf("order %v: replica %d not repaired",perm,i) }
+		}
+		
+		/* Once repaired, a read finds nothing to repair. */
+		q.Obtain(key)
+		if st := q.RepairStats(); st.Sent!=3 { t.Fatalf("order %v: repeated repair: %+v",perm,st) }
+	}
+	row,_ := datatypes.Table_Decode(want,true,true)
+	if len(row)!=12 { t.Fatalf("merged row has %d columns, want 12",len(row)) }
+}
+
+func TestRepairStale(t *testing.T) {
+	q,ms := newQuorum(datatypes.LWW_Factory,3)
+	q.R = 3
+	q.RepairChance = 1
+	key := []byte("k")
+	ms[0].Submit(key,datatypes.LWW_Put([]byte("v1")))
+	time.Sleep(time.Millisecond)
+	ms[1].Submit(key,datatypes.LWW_Put([]byte("v2")))
+	q.Obtain(key)
+	waitRepairs(t,q,2)
+	for i,m := range ms {
+		if v,_ := datatypes.LWW_Decode(m.Obtain(key)); string(v)!="v2" { t.Fatalf("replica %d: got %q, want v2",i,v) }
+	}
+	if st := q.RepairStats(); st.Sent!=2 { t.Fatalf("%+v: only the stale replicas must be repaired",st) }
+}
+
+func TestRepairRate(t *testing.T) {
+	q,ms := newQuorum(datatypes.LWW_Factory,3)
+	q.R = 3
+	q.RepairChance = 1
+	q.RepairRate = 0.001
+	key := []byte("k")
+	ms[0].Submit(key,datatypes.LWW_Put([]byte("v1")))
+	q.Obtain(key)
+	if st := q.RepairStats(); st.Sent!=1 || st.Dropped!=1 { t.Fatalf("%+v, want 1 sent and 1 dropped",st) }
+}
@@ -0,0 +1,96 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package quorum
+
+import "github.com/byte-mug/brute/api"
+import "math/rand"
+import "sync"
+import "sync/atomic"
+import "time"
+
+type RepairStats struct{
+	/* Repairs submitted, and those the stale replica accepted. */
+	Sent     uint64
+	Accepted uint64
+	
+	/* Repairs skipped due to RepairRate. */
+	Dropped  uint64
+}
+
+type repairs struct{
+	RepairStats
+	
+	lock   sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+/* A token bucket, holding up to one second worth of repairs. */
+func (r *repairs) allow(rate float64) bool {
+	if rate<=0 { return true }
+	r.lock.Lock(); defer r.lock.Unlock()
+	now := time.Now()
+	burst := rate
+	if burst<1 { burst = 1 }
+	if r.last.IsZero() {
+		r.tokens = burst
+	} else {
+		r.tokens += now.Sub(r.last).Seconds()*rate
+	}
+	r.last = now
+	if r.tokens>burst { r.tokens = burst }
+	if r.tokens<1 { return false }
+	r.tokens--
+	return true
+}
+
+/* Returns the read repair counters. */
+func (q *Quorum) RepairStats() RepairStats {
+	return RepairStats{
+		Sent:     atomic.LoadUint64(&q.repairs.Sent),
+		Accepted: atomic.LoadUint64(&q.repairs.Accepted),
+		Dropped:  atomic.LoadUint64(&q.repairs.Dropped),
+	}
+}
+
+/*
+Submits the merged item to every replica, whose reply lacked it: a replica is
+stale, if merging the merged item into its copy changes that copy.
+*/
+func (q *Quorum) repair(replicas []api.StorageFacade, replies []reply, key, item []byte) {
+	if rand.Float64()>=q.RepairChance { return }
+	for _,rp := range replies {
+		if rp.ok {
+			if _,ch := q.Merge(rp.item,item); !ch { continue }
+		}
+		if !q.repairs.allow(q.RepairRate) {
+			atomic.AddUint64(&q.repairs.Dropped,1)
+			continue
+		}
+		atomic.AddUint64(&q.repairs.Sent,1)
+		go func(s api.StorageFacade){
+			if s.Submit(key,item) { atomic.AddUint64(&q.repairs.Accepted,1) }
+		}(replicas[rp.idx])
+	}
+}
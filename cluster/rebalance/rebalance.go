@@ -0,0 +1,220 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package rebalance
+
+import "github.com/byte-mug/brute/cluster/ring"
+import "github.com/byte-mug/brute/replicator"
+import "github.com/byte-mug/brute/api"
+import "bytes"
+import "errors"
+import "sync/atomic"
+import "time"
+
+var ErrIncomplete = errors.New("rebalance: not all keys could be moved")
+
+type Progress struct{
+	/* Keys examined. */
+	Scanned   int64
+	
+	/* Items submitted to new owners, and their size (keys and items) in bytes. */
+	Sent      int64
+	SentBytes int64
+	
+	/* Keys, this node no longer owns, that have been removed. */
+	Deleted   int64
+	
+	/* Keys not removed, as they were written to after being sent. */
+	Changed   int64
+	
+	/* Submits and Deletes, that failed. */
+	Failed    int64
+}
+
+/*
+Moves the keys of one node after a membership change from the Old to the New
+ring. It should be run on every node (Node and Local referring to it), after
+the routing layer switched to the New ring.
+
+For every local key:
+
+	- if Node is the sender of the key (the first of its old owners, that is
+	  still member of the New ring), it submits the item to the new owners,
+	  that were no old owners.
+	- if Node does not own the key anymore, it submits the item to all new
+	  owners and removes the key, once every new owner accepted it.
+
+As items are merged, sending one twice is harmless.
+
+The Stream of Local only collects the keys to move; the items are obtained
+and sent afterwards, so no read transaction is held during the transfers.
+A key is removed only if its item did not change since it was sent (Local
+must implement api.CompareDeleter); otherwise it is kept and counted as
+Changed, and .Run() has to be repeated.
+
+Writes, that reach Local during the move, are picked up from UpLog and moved
+once all collected keys are done.
+*/
+type Rebalancer struct{
+	Node     string
+	Local    api.StorageFacade
+	Old, New *ring.Ring
+	
+	/* Returns the StorageFacade of a node. Returns nil, if it is unavailable. */
+	Resolve  func(node string) api.StorageFacade
+	
+	/* Optional: the update log of Local, to move concurrent writes. */
+	UpLog    replicator.LocalUpdateLog
+	
+	/* Optional: the bandwidth limit, in bytes per second. If 0, unlimited. */
+	Rate     int64
+	
+	progress Progress
+}
+
+/* Returns the progress of the running (or last) .Run(). */
+func (r *Rebalancer) Progress() Progress {
+	return Progress{
+		Scanned:   atomic.LoadInt64(&r.progress.Scanned),
+		Sent:      atomic.LoadInt64(&r.progress.Sent),
+		SentBytes: atomic.LoadInt64(&r.progress.SentBytes),
+		Deleted:   atomic.LoadInt64(&r.progress.Deleted),
+		Changed:   atomic.LoadInt64(&r.progress.Changed),
+		Failed:    atomic.LoadInt64(&r.progress.Failed),
+	}
+}
+
+func contains(nodes []string, node string) bool {
+	for _,n := range nodes {
+		if n==node { return true }
+	}
+	return false
+}
+
+func (r *Rebalancer) sender(old []string) string {
+	for _,n := range old {
+		if r.New.Has(n) { return n }
+	}
+	if len(old)==0 { return "" }
+	return old[0]
+}
+
+/* Sleeps, until the bytes sent so far fit into Rate. */
+func (r *Rebalancer) throttle(start time.Time) {
+	if r.Rate<=0 { return }
+	sent := atomic.LoadInt64(&r.progress.SentBytes)
+	due := start.Add(time.Duration(float64(sent)/float64(r.Rate)*float64(time.Second)))
+	if d := time.Until(due); d>0 { time.Sleep(d) }
+}
+
+func (r *Rebalancer) send(start time.Time, node string, key, item []byte) bool {
+	s := r.Resolve(node)
+	if s==nil || !s.Submit(key,item) {
+		atomic.AddInt64(&r.progress.Failed,1)
+		return false
+	}
+	atomic.AddInt64(&r.progress.Sent,1)
+	atomic.AddInt64(&r.progress.SentBytes,int64(len(key)+len(item)))
+	r.throttle(start)
+	return true
+}
+
+/* Returns true, if Node has to send or remove the key. */
+func (r *Rebalancer) affected(key []byte) bool {
+	old,nw := r.Old.Owners(key),r.New.Owners(key)
+	if !contains(nw,r.Node) { return len(nw)>0 }
+	if r.sender(old)!=r.Node { return false }
+	for _,n := range nw {
+		if !contains(old,n) { return true }
+	}
+	return false
+}
+
+/*
+Moves a key: sends its current item to the new owners and, if the key is no
+longer owned by Node and all of them accepted it, removes it.
+*/
+func (r *Rebalancer) move(start time.Time, key []byte) {
+	item,ok,_ := r.Local.Obtain(key)
+	if !ok { return }
+	item = append([]byte(nil),item...)
+	old,nw := r.Old.Owners(key),r.New.Owners(key)
+	if contains(nw,r.Node) {
+		if r.sender(old)!=r.Node { return }
+		for _,n := range nw {
+			if n!=r.Node && !contains(old,n) { r.send(start,n,key,item) }
+		}
+		return
+	}
+	if len(nw)==0 { return }
+	for _,n := range nw {
+		if !r.send(start,n,key,item) { return }
+	}
+	d,ok := r.Local.(api.CompareDeleter)
+	switch {
+	case !ok:
+		atomic.AddInt64(&r.progress.Failed,1)
+	case d.DeleteIf(key,item):
+		atomic.AddInt64(&r.progress.Deleted,1)
+	default:
+		if cur,ok,_ := r.Local.Obtain(key); ok && !bytes.Equal(cur,item) {
+			atomic.AddInt64(&r.progress.Changed,1)
+		} else if ok {
+			atomic.AddInt64(&r.progress.Failed,1)
+		}
+	}
+}
+
+/*
+Performs the rebalancing. Returns ErrIncomplete, if some items could not be
+sent or removed; in that case, .Run() can simply be repeated.
+*/
+func (r *Rebalancer) Run() error {
+	for _,p := range []*int64{&r.progress.Scanned,&r.progress.Sent,&r.progress.SentBytes,&r.progress.Deleted,&r.progress.Changed,&r.progress.Failed} {
+		atomic.StoreInt64(p,0)
+	}
+	start := time.Now()
+	
+	var keys [][]byte
+	r.Local.Stream(func(key, item []byte){
+		atomic.AddInt64(&r.progress.Scanned,1)
+		if r.affected(key) { keys = append(keys,append([]byte(nil),key...)) }
+	})
+	for _,key := range keys { r.move(start,key) }
+	
+	if r.UpLog!=nil {
+		ch := make(chan replicator.LocalUpdateEntry,128)
+		err := r.UpLog.ReadAllAsync(start.UTC(),ch)
+		if err!=nil { return err }
+		keys = keys[:0]
+		for lue := range ch {
+			atomic.AddInt64(&r.progress.Scanned,1)
+			if r.affected(lue.Key) { keys = append(keys,lue.Key) }
+		}
+		for _,key := range keys { r.move(start,key) }
+	}
+	
+	p := r.Progress()
+	if p.Failed>0 || p.Changed>0 { return ErrIncomplete }
+	return nil
+}
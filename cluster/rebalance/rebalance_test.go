@@ -0,0 +1,189 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package rebalance
+
+import "github.com/byte-mug/brute/cluster/ring"
+import "github.com/byte-mug/brute/datatypes"
+import "github.com/byte-mug/brute/utils"
+import "github.com/byte-mug/brute/api"
+import "bytes"
+import "fmt"
+import "sort"
+import "sync"
+import "testing"
+
+/* An in-memory StorageFacade, implementing api.CompareDeleter. */
+type mem struct{
+	utils.MergeUtil
+	lock sync.Mutex
+	m    map[string][]byte
+}
+func newMem() *mem {
+	m := &mem{m:make(map[string][]byte)}
+	m.Merger = datatypes.LWW_Factory
+	return m
+}
+func (m *mem) Submit(key, item []byte) bool {
+	m.lock.Lock(); defer m.lock.Unlock()
+	if o,ok := m.m[string(key)]; ok { item,_ = m.Merge(o,item) }
+	m.m[string(key)] = item
+	return true
+}
+func (m *mem) Obtain(key []byte) ([]byte,bool,bool) {
+	m.lock.Lock(); defer m.lock.Unlock()
+	item,ok := m.m[string(key)]
+	return item,ok,true
+}
+func (m *mem) Stream(f func(key, item []byte)) {
+	m.lock.Lock()
+	keys := make([]string,0,len(m.m))
+	for k := range m.m { keys = append(keys,k) }
+	m.lock.Unlock()
+	sort.Strings(keys)
+	for _,k := range keys {
+		item,_,_ := m.Obtain([]byte(k))
+		f([]byte(k),item)
+	}
+}
+func (m *mem) DeleteIf(key, item []byte) bool {
+	m.lock.Lock(); defer m.lock.Unlock()
+	if cur,ok := m.m[string(key)]; !ok || !bytes.Equal(cur,item) { return false }
+	delete(m.m,string(key))
+	return true
+}
+
+/* A StorageFacade without api.CompareDeleter. */
+type plain struct{ api.StorageFacade }
+
+/* Calls a hook before every Submit. */
+type hooked struct{
+	api.StorageFacade
+	hook func(key []byte)
+}
+func (h *hooked) Submit(key, item []byte) bool {
+	h.hook(key)
+	return h.StorageFacade.Submit(key,item)
+}
+
+type cluster struct{
+	old, nw *ring.Ring
+	nodes   map[string]*mem
+}
+func newCluster(keys int) *cluster {
+	c := &cluster{old:&ring.Ring{Replicas:2},nodes:make(map[string]*mem)}
+	c.old.Add("a","b")
+	c.nw = c.old.Clone()
+	c.nw.Add("c")
+	for _,n := range []string{"a","b","c"} { c.nodes[n] = newMem() }
+	for i := 0; i<keys; i++ {
+		key := []byte(fmt.Sprint(i))
+		for _,n := range c.old.Owners(key) { c.nodes[n].Submit(key,datatypes.LWW_Put(key)) }
+	}
+	return c
+}
+func (c *cluster) resolve(node string) api.StorageFacade { return c.nodes[node] }
+func (c *cluster) rebalancer(node string) *Rebalancer {
+	return &Rebalancer{Node:node,Local:c.nodes[node],Old:c.old,New:c.nw,Resolve:c.resolve}
+}
+/* Returns a key, that node has to give away. */
+func (c *cluster) moving(t *testing.T, node string, keys int) []byte {
+	for i := 0; i<keys; i++ {
+		key := []byte(fmt.Sprint(i))
+		if c.old.Owns(node,key) && !c.nw.Owns(node,key) { return key }
+	}
+	t.Fatalf("%s gives away no key",node)
+	return nil
+}
+
+func TestRebalance(t *testing.T) {
+	const keys = 300
+	c := newCluster(keys)
+	for _,n := range []string{"a","b"} {
+		r := c.rebalancer(n)
+		r.Rate = 1<<24
+		done := make(chan struct{})
+		go func() {
+			/* Progress may be read, while .Run() is going on. */
+			for {
+				select {
+				case <-done: return
+				default: r.Progress()
+				}
+			}
+		}()
+		err := r.Run()
+		close(done)
+		if err!=nil { t.Fatalf("%s: %v %+v",n,err,r.Progress()) }
+		if p := r.Progress(); p.Scanned==0 || p.Sent==0 { t.Fatalf("%s: %+v",n,p) }
+	}
+	for i := 0; i<keys; i++ {
+		key := []byte(fmt.Sprint(i))
+		for n,m := range c.nodes {
+			_,ok,_ := m.Obtain(key)
+			if ok!=c.nw.Owns(n,key) { t.Fatalf("%s: key %d: stored %v, owned %v",n,i,ok,!ok) }
+		}
+	}
+}
+
+func TestChangedNotDeleted(t *testing.T) {
+	const keys = 100
+	c := newCluster(keys)
+	key := c.moving(t,"a",keys)
+	r := c.rebalancer("a")
+	
+	/* A write reaches the old owner, while the item is being sent. */
+	once := false
+	r.Resolve = func(node string) api.StorageFacade {
+		return &hooked{c.nodes[node],func(k []byte) {
+			if once || !bytes.Equal(k,key) { return }
+			once = true
+			c.nodes["a"].Submit(key,datatypes.LWW_Put([]byte("late")))
+		}}
+	}
+	if err := r.Run(); err!=ErrIncomplete { t.Fatalf("got %v, want ErrIncomplete",err) }
+	if p := r.Progress(); p.Changed!=1 { t.Fatalf("%+v",p) }
+	v,ok := datatypes.LWW_Decode(c.nodes["a"].Obtain(key))
+	if !ok || string(v)!="late" { t.Fatalf("changed item removed: %q",v) }
+	
+	/* A second run moves the late write as well. */
+	if err := r.Run(); err!=nil { t.Fatal(err) }
+	if _,ok,_ := c.nodes["a"].Obtain(key); ok { t.Fatal("key kept") }
+	for _,n := range c.nw.Owners(key) {
+		v,ok := datatypes.LWW_Decode(c.nodes[n].Obtain(key))
+		if !ok || string(v)!="late" { t.Fatalf("%s: got %q",n,v) }
+	}
+}
+
+func TestNoCompareDeleter(t *testing.T) {
+	const keys = 100
+	c := newCluster(keys)
+	key := c.moving(t,"a",keys)
+	r := c.rebalancer("a")
+	r.Local = plain{c.nodes["a"]}
+	if err := r.Run(); err!=ErrIncomplete { t.Fatalf("got %v, want ErrIncomplete",err) }
+	if _,ok,_ := c.nodes["a"].Obtain(key); !ok { t.Fatal("key removed") }
+	for _,n := range c.nw.Owners(key) {
+		if _,ok,_ := c.nodes[n].Obtain(key); !ok { t.Fatalf("%s: not sent",n) }
+	}
+}
@@ -0,0 +1,168 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package ring
+
+import "github.com/dgryski/go-farm"
+import "sort"
+import "strconv"
+import "sync"
+
+const (
+	/* The default value for Ring.VNodes. */
+	DefaultVNodes = 128
+	
+	/* The default value for Ring.Replicas. */
+	DefaultReplicas = 3
+)
+
+type point struct{
+	hash uint64
+	node string
+}
+
+/*
+A consistent-hashing ring. Every node is placed onto the ring at VNodes
+positions (virtual nodes), so that keys are spread evenly and a membership
+change only moves about 1/N of the keys.
+
+The owners of a key are the first Replicas distinct nodes found walking the
+ring clockwise, starting at the hash of the key (the preference list).
+*/
+type Ring struct{
+	/* The number of virtual nodes per node. If 0, DefaultVNodes is used. */
+	VNodes   int
+	
+	/* The replication factor. If 0, DefaultReplicas is used. */
+	Replicas int
+	
+	lock     sync.RWMutex
+	nodes    map[string]bool
+	points   []point
+}
+func (r *Ring) vnodes() int {
+	if r.VNodes<=0 { return DefaultVNodes }
+	return r.VNodes
+}
+func (r *Ring) replicas() int {
+	if r.Replicas<=0 { return DefaultReplicas }
+	return r.Replicas
+}
+func (r *Ring) rebuild() {
+	pts := make([]point,0,len(r.nodes)*r.vnodes())
+	for node := range r.nodes {
+		for i := 0; i<r.vnodes(); i++ {
+			pts = append(pts,point{farm.Hash64([]byte(node+"#"+strconv.Itoa(i))),node})
+		}
+	}
+	sort.Slice(pts,func(i,j int) bool {
+		if pts[i].hash!=pts[j].hash { return pts[i].hash<pts[j].hash }
+		return pts[i].node<pts[j].node
+	})
+	r.points = pts
+}
+
+/* Adds nodes to the ring. */
+func (r *Ring) Add(nodes ...string) {
+	r.lock.Lock(); defer r.lock.Unlock()
+	if r.nodes==nil { r.nodes = make(map[string]bool) }
+	for _,n := range nodes { r.nodes[n] = true }
+	r.rebuild()
+}
+
+/* Removes nodes from the ring. */
+func (r *Ring) Remove(nodes ...string) {
+	r.lock.Lock(); defer r.lock.Unlock()
+	for _,n := range nodes { delete(r.nodes,n) }
+	r.rebuild()
+}
+
+/* Returns the nodes of the ring, sorted by name. */
+func (r *Ring) Nodes() []string {
+	r.lock.RLock(); defer r.lock.RUnlock()
+	ns := make([]string,0,len(r.nodes))
+	for n := range r.nodes { ns = append(ns,n) }
+	sort.Strings(ns)
+	return ns
+}
+
+/* Returns true, if node is member of the ring. */
+func (r *Ring) Has(node string) bool {
+	r.lock.RLock(); defer r.lock.RUnlock()
+	return r.nodes[node]
+}
+
+/* Returns a copy of the ring, that is not affected by later changes. */
+func (r *Ring) Clone() *Ring {
+	r.lock.RLock(); defer r.lock.RUnlock()
+	c := &Ring{VNodes:r.VNodes,Replicas:r.Replicas,nodes:make(map[string]bool,len(r.nodes))}
+	for n := range r.nodes { c.nodes[n] = true }
+	c.points = r.points
+	return c
+}
+
+func (r *Ring) walk(key []byte, n int, f func(node string) bool) {
+	r.lock.RLock(); defer r.lock.RUnlock()
+	if len(r.points)==0 { return }
+	if n>len(r.nodes) { n = len(r.nodes) }
+	h := farm.Hash64(key)
+	i := sort.Search(len(r.points),func(i int) bool { return r.points[i].hash>=h })
+	seen := make(map[string]bool,n)
+	for j := 0; len(seen)<n && j<len(r.points); j++ {
+		p := r.points[(i+j)%len(r.points)]
+		if seen[p.node] { continue }
+		seen[p.node] = true
+		if !f(p.node) { return }
+	}
+}
+
+/*
+Returns the preference list of key: its owners, the first being the primary
+one. If the ring has less nodes than Replicas, all nodes are returned.
+*/
+func (r *Ring) Owners(key []byte) []string {
+	ns := make([]string,0,r.replicas())
+	r.walk(key,r.replicas(),func(node string) bool {
+		ns = append(ns,node)
+		return true
+	})
+	return ns
+}
+
+/* Returns the primary owner of key, or "" if the ring is empty. */
+func (r *Ring) Primary(key []byte) (node string) {
+	r.walk(key,1,func(n string) bool {
+		node = n
+		return false
+	})
+	return
+}
+
+/* Returns true, if node is one of the owners of key. */
+func (r *Ring) Owns(node string, key []byte) (ok bool) {
+	r.walk(key,r.replicas(),func(n string) bool {
+		ok = n==node
+		return !ok
+	})
+	return
+}
@@ -0,0 +1,161 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package ring
+
+import "github.com/byte-mug/brute/cluster/handoff"
+import "github.com/byte-mug/brute/datatypes"
+import "github.com/byte-mug/brute/utils"
+import "github.com/byte-mug/brute/api"
+import bolt "github.com/coreos/bbolt"
+import "fmt"
+import "path/filepath"
+import "sort"
+import "sync"
+import "testing"
+
+/* An in-memory StorageFacade. */
+type mem struct{
+	utils.MergeUtil
+	lock sync.Mutex
+	m    map[string][]byte
+}
+func newMem(f api.MergerFactory) *mem {
+	m := &mem{m:make(map[string][]byte)}
+	m.Merger = f
+	return m
+}
+func (m *mem) Submit(key, item []byte) bool {
+	m.lock.Lock(); defer m.lock.Unlock()
+	if o,ok := m.m[string(key)]; ok { item,_ = m.Merge(o,item) }
+	m.m[string(key)] = item
+	return true
+}
+func (m *mem) Obtain(key []byte) ([]byte,bool,bool) {
+	m.lock.Lock(); defer m.lock.Unlock()
+	item,ok := m.m[string(key)]
+	return item,ok,true
+}
+func (m *mem) Stream(f func(key, item []byte)) {
+	m.lock.Lock()
+	keys := make([]string,0,len(m.m))
+	for k := range m.m { keys = append(keys,k) }
+	m.lock.Unlock()
+	sort.Strings(keys)
+	for _,k := range keys {
+		item,_,_ := m.Obtain([]byte(k))
+		f([]byte(k),item)
+	}
+}
+func (m *mem) len() int {
+	m.lock.Lock(); defer m.lock.Unlock()
+	return len(m.m)
+}
+
+func TestOwners(t *testing.T) {
+	r := &Ring{}
+	r.Add("a","b","c","d")
+	primary := make(map[string]string)
+	count := make(map[string]int)
+	for i := 0; i<10000; i++ {
+		key := []byte(fmt.Sprint(i))
+		o := r.Owners(key)
+		if len(o)!=3 || o[0]==o[1] || o[1]==o[2] || o[0]==o[2] { t.Fatalf("owners %v",o) }
+		if o[0]!=r.Primary(key) || !r.Owns(o[2],key) { t.Fatalf("owners %v disagree with Primary or Owns",o) }
+		primary[string(key)] = o[0]
+		count[o[0]]++
+	}
+	for n,c := range count {
+		if c<1500 || c>3500 { t.Errorf("node %s is primary of %d of 10000 keys",n,c) }
+	}
+	
+	/* Adding a fifth node moves about a fifth of the keys. */
+	c := r.Clone()
+	r.Add("e")
+	moved := 0
+	for k,p := range primary {
+		if r.Primary([]byte(k))!=p { moved++ }
+		if c.Primary([]byte(k))!=p { t.Fatal("a clone was affected by a change of the ring") }
+	}
+	if moved<1000 || moved>3000 { t.Errorf("%d of 10000 keys moved",moved) }
+	
+	r.Remove("a","b","c","d")
+	if o := r.Owners([]byte("k")); len(o)!=1 || o[0]!="e" { t.Fatalf("owners %v of a ring of one node",o) }
+}
+
+func newRouter(nodes ...string) (*Router,map[string]*mem) {
+	r := &Ring{Replicas:2}
+	r.Add(nodes...)
+	ms := make(map[string]*mem)
+	for _,n := range nodes { ms[n] = newMem(datatypes.LWW_Factory) }
+	rt := &Router{Ring:r,Resolve:func(n string) api.StorageFacade {
+		if m,ok := ms[n]; ok { return m }
+		return nil
+	}}
+	rt.Quorum.Merger = datatypes.LWW_Factory
+	return rt,ms
+}
+
+func TestRouter(t *testing.T) {
+	rt,ms := newRouter("a","b","c")
+	for i := 0; i<100; i++ {
+		if !rt.Submit([]byte(fmt.Sprint(i)),datatypes.LWW_Put([]byte("v"))) { t.Fatalf("Submit %d failed",i) }
+	}
+	if n := ms["a"].len()+ms["b"].len()+ms["c"].len(); n!=200 { t.Fatalf("%d copies, want 200",n) }
+	if v,ok := datatypes.LWW_Decode(rt.Obtain([]byte("5"))); !ok || string(v)!="v" { t.Fatalf("got %q %v",v,ok) }
+	
+	/* A node is down: every key is still streamed once. */
+	delete(ms,"a")
+	seen := make(map[string]int)
+	rt.Stream(func(key, item []byte){ seen[string(key)]++ })
+	if len(seen)!=100 { t.Fatalf("streamed %d keys, want 100",len(seen)) }
+	for k,n := range seen {
+		if n!=1 { t.Fatalf("key %s streamed %d times",k,n) }
+	}
+}
+
+func TestRouterHints(t *testing.T) {
+	db,err := bolt.Open(filepath.Join(t.TempDir(),"hints.db"),0600,nil)
+	if err!=nil { t.Fatal(err) }
+	defer db.Close()
+	rt,ms := newRouter("a","b","c")
+	rt.Hints = &handoff.Hints{DB:db}
+	a := ms["a"]
+	delete(ms,"a")
+	
+	var keys []string
+	for i := 0; i<50; i++ {
+		key := []byte(fmt.Sprint(i))
+		if !rt.Ring.Owns("a",key) { continue }
+		/* Hints do not count towards the write quorum of 2. */
+		if rt.Submit(key,datatypes.LWW_Put([]byte("v"))) { t.Fatalf("Submit %d succeeded",i) }
+		keys = append(keys,string(key))
+	}
+	if p := rt.Hints.Pending("a"); p!=len(keys) { t.Fatalf("%d hints for a, want %d",p,len(keys)) }
+	
+	ms["a"] = a
+	if n,err := rt.Replay(); n!=len(keys) || err!=nil { t.Fatalf("replayed %d %v, want %d",n,err,len(keys)) }
+	for _,k := range keys {
+		if _,ok,_ := a.Obtain([]byte(k)); !ok { t.Fatalf("key %s was not handed off",k) }
+	}
+}
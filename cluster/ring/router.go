@@ -0,0 +1,108 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package ring
+
+import "github.com/byte-mug/brute/cluster/handoff"
+import "github.com/byte-mug/brute/cluster/quorum"
+import "github.com/byte-mug/brute/api"
+
+type unavailable struct{}
+func (unavailable) Submit(key, item []byte) bool { return false }
+func (unavailable) Obtain(key []byte) ([]byte,bool,bool) { return nil,false,false }
+func (unavailable) Stream(f func(key, item []byte)) {}
+
+/*
+A StorageFacade, that partitions the keys across the nodes of a Ring. Every
+.Submit() and .Obtain() is sent to the owners of the key, using the read and
+write quorums of Quorum (Quorum.Replicas is not used).
+*/
+type Router struct{
+	Ring    *Ring
+	Quorum  quorum.Quorum
+	
+	/*
+	Returns the StorageFacade of a node, usually an *httpi.Client. Returns nil,
+	if the node is unavailable.
+	*/
+	Resolve func(node string) api.StorageFacade
+	
+	/*
+	Optional: if set, writes, an owner did not accept, are stored as hints and
+	delivered later, using .Replay().
+	*/
+	Hints   *handoff.Hints
+}
+func (r *Router) node(name string) api.StorageFacade {
+	s := r.Resolve(name)
+	if s==nil { s = unavailable{} }
+	if r.Hints!=nil { s = r.Hints.Wrap(name,s) }
+	return s
+}
+
+/*
+Delivers the stored hints to the nodes, that are available. Should be called,
+when a node came back (see gossip.ReplayHints), and periodically, as hints are
+only stored, never delivered, otherwise.
+*/
+func (r *Router) Replay() (int,error) {
+	if r.Hints==nil { return 0,nil }
+	return r.Hints.ReplayAll(r.Resolve)
+}
+func (r *Router) owners(key []byte) []api.StorageFacade {
+	ns := r.Ring.Owners(key)
+	ss := make([]api.StorageFacade,len(ns))
+	for i,n := range ns { ss[i] = r.node(n) }
+	return ss
+}
+
+func (r *Router) Submit(key, item []byte) bool {
+	return r.Quorum.SubmitTo(r.owners(key),key,item)
+}
+func (r *Router) Obtain(key []byte) (item []byte,ok,readable bool) {
+	return r.Quorum.ObtainFrom(r.owners(key),key)
+}
+
+/*
+Streams all available nodes, one after another, reporting every key once: the
+first copy found is reported. As every node is streamed, keys whose primary
+owner is down, or has not received them yet after a ring change, are still
+reported.
+
+The item reported is the copy of a single node, not a quorum read. The keys
+reported so far are kept in memory.
+*/
+func (r *Router) Stream(f func(key, item []byte)) {
+	seen := make(map[string]struct{})
+	for _,n := range r.Ring.Nodes() {
+		s := r.Resolve(n)
+		if s==nil { continue }
+		s.Stream(func(key, item []byte){
+			if _,ok := seen[string(key)]; ok { return }
+			seen[string(key)] = struct{}{}
+			f(key,item)
+		})
+	}
+}
+
+var _ api.StorageFacade = (*Router)(nil)
@@ -0,0 +1,119 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package datatypes
+
+import "github.com/byte-mug/golibs/msgpackx"
+import "github.com/byte-mug/brute/api"
+import "github.com/vmihailenco/msgpack"
+import "sort"
+
+/*
+A PN-Counter: every node keeps the sum of its increments and the sum of its
+decrements, both of which only grow. Merging takes the maximum per node, the
+value is the sum of all increments minus the sum of all decrements.
+
+	node => [increments,decrements]
+*/
+type counterState map[string][]int64
+/* Encodes the nodes sorted by name (see marshal). */
+func (c counterState) EncodeMsgpack(enc *msgpack.Encoder) error {
+	if c==nil { return enc.EncodeNil() }
+	nodes := make([]string,0,len(c))
+	for n := range c { nodes = append(nodes,n) }
+	sort.Strings(nodes)
+	if err := enc.EncodeMapLen(len(nodes)); err!=nil { return err }
+	for _,n := range nodes {
+		if err := enc.EncodeString(n); err!=nil { return err }
+		if err := enc.Encode(c[n]); err!=nil { return err }
+	}
+	return nil
+}
+
+func counterEntry(v []int64) (p,n int64) {
+	if len(v)>0 { p = v[0] }
+	if len(v)>1 { n = v[1] }
+	return
+}
+
+type CounterMerger struct{
+	changed bool
+	state counterState
+}
+func (c *CounterMerger) Init(item []byte) {
+	c.changed = false
+	c.state = nil
+	var m counterState
+	if msgpackx.Unmarshal(item,&m)!=nil { return }
+	c.state = m
+}
+func (c *CounterMerger) Merge(item []byte) {
+	var m counterState
+	if msgpackx.Unmarshal(item,&m)!=nil { return }
+	if c.state==nil { c.state = make(counterState) }
+	for node,v := range m {
+		p,n := counterEntry(v)
+		op,on := counterEntry(c.state[node])
+		if p>op { op = p; c.changed = true }
+		if n>on { on = n; c.changed = true }
+		c.state[node] = []int64{op,on}
+	}
+}
+func (c *CounterMerger) Changed() bool {
+	return c.changed
+}
+func (c *CounterMerger) Result() []byte {
+	bts,_ := marshal(c.state)
+	return bts
+}
+func (c *CounterMerger) Cleanup() { c.state = nil }
+
+var _ api.Merger = (*CounterMerger)(nil)
+func Counter_Factory() api.Merger { return new(CounterMerger) }
+
+/*
+Adds 'delta' on behalf of 'node' to the counter stored as 'item' (which may be
+nil), returning the item to submit.
+
+Every node must use its own name, and must serialize its own updates to a key.
+*/
+func Counter_Add(item []byte, node string, delta int64) []byte {
+	var m counterState
+	msgpackx.Unmarshal(item,&m)
+	p,n := counterEntry(m[node])
+	if delta<0 { n -= delta } else { p += delta }
+	bts,_ := marshal(counterState{node:{p,n}})
+	return bts
+}
+
+func Counter_Decode(item []byte, in_ok,readable bool) (value int64,ok bool) {
+	ok = in_ok
+	if !(ok&&readable) { return 0,false }
+	var m counterState
+	if msgpackx.Unmarshal(item,&m)!=nil { return 0,false }
+	for _,v := range m {
+		p,n := counterEntry(v)
+		value += p-n
+	}
+	return
+}
@@ -0,0 +1,63 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package datatypes
+
+import "fmt"
+import "testing"
+
+func mergeCounter(items ...[]byte) []byte {
+	m := Counter_Factory()
+	m.Init(nil)
+	for _,item := range items { m.Merge(item) }
+	return m.Result()
+}
+
+func TestCounter(t *testing.T) {
+	var items [][]byte
+	var n1,n2 []byte
+	for i := 0; i<5; i++ {
+		n1 = Counter_Add(mergeCounter(items...),"n1",2)
+		n2 = Counter_Add(mergeCounter(items...),"n2",-1)
+		items = append(items,n1,n2)
+	}
+	v,ok := Counter_Decode(mergeCounter(items...),true,true)
+	if !ok || v!=5 { t.Fatalf("got %d %v, want 5",v,ok) }
+	
+	/* Merging an older state again changes nothing. */
+	m := Counter_Factory()
+	m.Init(mergeCounter(items...))
+	m.Merge(items[0])
+	if m.Changed() { t.Fatal("an older state changed the counter") }
+}
+
+/* Equal counters must be equal items, whatever the order of the merges. */
+func TestCounterCanonical(t *testing.T) {
+	var items [][]byte
+	for i := 0; i<16; i++ { items = append(items,Counter_Add(nil,fmt.Sprint("n",i),int64(i))) }
+	rev := make([][]byte,len(items))
+	for i,item := range items { rev[len(items)-1-i] = item }
+	r1,r2 := mergeCounter(items...),mergeCounter(rev...)
+	if string(r1)!=string(r2) { t.Fatalf("%x != %x",r1,r2) }
+	if v,_ := Counter_Decode(r1,true,true); v!=120 { t.Fatalf("got %d, want 120",v) }
+}
@@ -71,6 +71,20 @@ func (lww *LastWriteWins) Cleanup() {
 var _ api.Merger = (*LastWriteWins)(nil)
 func LWW_Factory() api.Merger { return new(LastWriteWins) }
 
+/*
+Encodes a sequence of values like msgpackx.Marshal, but with sorted map keys,
+so that equal values yield equal items. The Merkle trees of replicator/merkle
+hash the stored items and rely on that.
+*/
+func marshal(v ...interface{}) ([]byte,error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf).SortMapKeys(true)
+	for _,x := range v {
+		if err := enc.Encode(x); err!=nil { return nil,err }
+	}
+	return buf.Bytes(),nil
+}
+
 func LWW_Put(value []byte) (item []byte) {
 	t := time.Now().UTC()
 	item,_ = msgpackx.Marshal(t,true,value)
@@ -23,6 +23,7 @@ SOFTWARE.
 
 package datatypes
 
+import "sort"
 import "time"
 import "github.com/vmihailenco/msgpack"
 import "github.com/byte-mug/golibs/msgpackx"
@@ -43,6 +44,19 @@ func (t *tableRowField) EncodeMsgpack(enc *msgpack.Encoder) error {
 }
 
 type tableRow map[string]*tableRowField
+/* Encodes the fields sorted by name (see marshal). */
+func (t tableRow) EncodeMsgpack(enc *msgpack.Encoder) error {
+	if t==nil { return enc.EncodeNil() }
+	keys := make([]string,0,len(t))
+	for k := range t { keys = append(keys,k) }
+	sort.Strings(keys)
+	if err := enc.EncodeMapLen(len(keys)); err!=nil { return err }
+	for _,k := range keys {
+		if err := enc.EncodeString(k); err!=nil { return err }
+		if err := enc.Encode(t[k]); err!=nil { return err }
+	}
+	return nil
+}
 
 type TableMerger struct {
 	changed bool
@@ -65,6 +79,7 @@ func (t *TableMerger) Merge(item []byte) {
 	if msgpackx.Unmarshal(item,&dts,&m)!=nil { return }
 	if t.currentRow==nil {
 		t.currentRow = m
+		t.dts = dts
 		t.changed = true
 	} else {
 		if t.dts.Before(dts) { t.dts = dts } else { dts = t.dts }
@@ -95,7 +110,7 @@ func (t *TableMerger) Changed() bool {
 	return t.changed
 }
 func (t *TableMerger) Result() []byte {
-	bts,_ := msgpackx.Marshal(t.dts,t.currentRow)
+	bts,_ := marshal(t.dts,t.currentRow)
 	return bts
 }
 func (t *TableMerger) Cleanup() { t.currentRow = nil }
@@ -110,13 +125,22 @@ func Table_Put(src Row) (item []byte) {
 	m := make(tableRow)
 	for k,v := range src { m[k] = &tableRowField{t,v} }
 	
-	item,_ = msgpackx.Marshal(time.Time{},m)
+	item,_ = marshal(time.Time{},m)
+	return
+}
+/* Like Table_Put, but replaces the whole row, deleting all fields not in 'src'. */
+func Table_Replace(src Row) (item []byte) {
+	t := time.Now().UTC()
+	m := make(tableRow)
+	for k,v := range src { m[k] = &tableRowField{t,v} }
+	
+	item,_ = marshal(t,m)
 	return
 }
 func Table_Delete() (item []byte) {
 	t := time.Now().UTC()
 	m := make(tableRow)
-	item,_ = msgpackx.Marshal(t,m)
+	item,_ = marshal(t,m)
 	return
 }
 
@@ -0,0 +1,94 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package datatypes
+
+import "fmt"
+import "testing"
+import "time"
+
+/* Merges the items into an absent row, in the given order. */
+func mergeTable(items ...[]byte) (Row,[]byte) {
+	m := Table_Factory()
+	m.Init(nil)
+	for _,item := range items { m.Merge(item) }
+	res := m.Result()
+	row,_ := Table_Decode(res,true,true)
+	return row,res
+}
+
+/* Compares rows by their values' string representation, as numbers decode as the smallest type. */
+func sameRow(a, b Row) bool {
+	return fmt.Sprint(a)==fmt.Sprint(b)
+}
+
+func TestTableCommutative(t *testing.T) {
+	put := Table_Put(Row{"a":1})
+	time.Sleep(time.Millisecond)
+	repl := Table_Replace(Row{"b":2})
+	time.Sleep(time.Millisecond)
+	put2 := Table_Put(Row{"c":3})
+	
+	want := Row{"b":2,"c":3}
+	orders := [][][]byte{
+		{put,repl,put2},
+		{put,put2,repl},
+		{repl,put,put2},
+		{repl,put2,put},
+		{put2,put,repl},
+		{put2,repl,put},
+	}
+	for i,items := range orders {
+		row,_ := mergeTable(items...)
+		if !sameRow(row,want) { t.Errorf("order %d: got %v, want %v",i,row,want) }
+	}
+}
+
+func TestTableIdempotent(t *testing.T) {
+	repl := Table_Replace(Row{"a":1})
+	time.Sleep(time.Millisecond)
+	put := Table_Put(Row{"b":2})
+	row,res := mergeTable(repl,put)
+	
+	for _,item := range [][]byte{repl,put,res} {
+		m := Table_Factory()
+		m.Init(res)
+		m.Merge(item)
+		if m.Changed() { t.Errorf("merging %x again changed the row",item) }
+		again,_ := Table_Decode(m.Result(),true,true)
+		if !sameRow(again,row) { t.Errorf("got %v, want %v",again,row) }
+	}
+	
+	row2,_ := mergeTable(repl,repl,put,put)
+	if !sameRow(row2,row) { t.Errorf("got %v, want %v",row2,row) }
+}
+
+/* Equal rows must be equal items, whatever the order of the merges. */
+func TestTableCanonical(t *testing.T) {
+	a,b := Row{},Row{}
+	for i := 0; i<16; i++ { a[fmt.Sprint("a",i)] = i; b[fmt.Sprint("b",i)] = i }
+	pa,pb := Table_Put(a),Table_Put(b)
+	_,r1 := mergeTable(pa,pb)
+	_,r2 := mergeTable(pb,pa)
+	if string(r1)!=string(r2) { t.Fatalf("%x != %x",r1,r2) }
+}
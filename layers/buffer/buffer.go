@@ -0,0 +1,181 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package buffer
+
+import "github.com/byte-mug/brute/utils"
+import "github.com/byte-mug/brute/api"
+import "sync"
+import "time"
+
+/* The default value for Buffer.MaxKeys. */
+const DefaultMaxKeys = 4096
+
+/*
+A write-coalescing buffer in front of any StorageFacade.
+
+As merges are idempotent and commutative, submits to the same key are merged
+in memory and written to the underlying StorageFacade once, on .Flush().
+*/
+type Buffer struct{
+	utils.MergeUtil
+	Api      api.StorageFacade
+	
+	/* Flush, once this many keys are buffered. If 0, DefaultMaxKeys is used. */
+	MaxKeys  int
+	
+	/* If not 0, the buffer is flushed at least this often (see .Init()). */
+	MaxDelay time.Duration
+	
+	/*
+	Optional: called by .Flush() with the number of items, that could not be
+	written (and remain buffered). Flushes run by .Submit() and the background
+	flusher are reported only here.
+	*/
+	Failed   func(n int)
+	
+	lock     sync.Mutex
+	flock    sync.Mutex
+	pend     map[string][]byte
+	inflight map[string][]byte
+	
+	stop     chan struct{}
+	done     chan struct{}
+}
+func (b *Buffer) maxKeys() int {
+	if b.MaxKeys<=0 { return DefaultMaxKeys }
+	return b.MaxKeys
+}
+
+/* Starts the background flusher, if .MaxDelay is set. */
+func (b *Buffer) Init() {
+	if b.MaxDelay<=0 || b.stop!=nil { return }
+	b.stop = make(chan struct{})
+	b.done = make(chan struct{})
+	go b.flusher(b.MaxDelay,b.stop,b.done)
+}
+func (b *Buffer) flusher(d time.Duration, stop, done chan struct{}) {
+	defer close(done)
+	t := time.NewTicker(d)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C: b.Flush()
+		case <-stop: return
+		}
+	}
+}
+
+/* Stops the background flusher and flushes the buffer. */
+func (b *Buffer) Close() bool {
+	if b.stop!=nil {
+		close(b.stop)
+		<-b.done
+		b.stop = nil
+	}
+	return b.Flush()
+}
+
+func (b *Buffer) add(key string, item []byte) int {
+	b.lock.Lock(); defer b.lock.Unlock()
+	return b.addLocked(key,item)
+}
+func (b *Buffer) addLocked(key string, item []byte) int {
+	if b.pend==nil { b.pend = make(map[string][]byte) }
+	if o,ok := b.pend[key]; ok {
+		item,_ = b.Merge(o,item)
+	}
+	b.pend[key] = item
+	return len(b.pend)
+}
+
+/*
+Writes all buffered items to the underlying StorageFacade.
+
+Returns false, if any item could not be written. Those items remain buffered.
+*/
+func (b *Buffer) Flush() (ok bool) {
+	b.flock.Lock(); defer b.flock.Unlock()
+	
+	b.lock.Lock()
+	b.inflight = b.pend
+	b.pend = nil
+	b.lock.Unlock()
+	
+	ok = true
+	var failed map[string][]byte
+	for key,item := range b.inflight {
+		if b.Api.Submit([]byte(key),item) { continue }
+		ok = false
+		if failed==nil { failed = make(map[string][]byte) }
+		failed[key] = item
+	}
+	
+	/* Re-buffer failed items in the same step, so they stay visible to .Obtain(). */
+	b.lock.Lock()
+	b.inflight = nil
+	for key,item := range failed { b.addLocked(key,item) }
+	b.lock.Unlock()
+	if len(failed)>0 && b.Failed!=nil { b.Failed(len(failed)) }
+	return
+}
+
+/*
+Buffers (and merges) a key-item-pair.
+
+As the item is written later, this function always returns true. If it
+triggers a flush, that fails, the failed items (including this one) remain
+buffered; the failure is reported to .Failed.
+*/
+func (b *Buffer) Submit(key, item []byte) (ok bool) {
+	item = append(make([]byte,0,len(item)),item...)
+	if b.add(string(key),item)>=b.maxKeys() { b.Flush() }
+	return true
+}
+/*
+Merges the stored item with the buffered ones. The buffered items are taken
+before reading Api, so an item, a concurrent .Flush() moves from the buffer
+to Api, is seen in at least one of both.
+*/
+func (b *Buffer) Obtain(key []byte) (item []byte,ok,readable bool) {
+	items := make([][]byte,0,3)
+	b.lock.Lock()
+	if i,has := b.inflight[string(key)]; has { items = append(items,i) }
+	if i,has := b.pend[string(key)]; has { items = append(items,i) }
+	b.lock.Unlock()
+	item,ok,readable = b.Api.Obtain(key)
+	if !readable { return }
+	if ok { items = append(items,item) }
+	if len(items)==0 { return }
+	item,_ = b.Merge(items...)
+	ok = true
+	return
+}
+
+/* Flushes the buffer and streams the underlying StorageFacade. */
+func (b *Buffer) Stream(f func(key, item []byte)) {
+	b.Flush()
+	b.Api.Stream(f)
+}
+
+var _ api.StorageFacade = (*Buffer)(nil)
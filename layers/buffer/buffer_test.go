@@ -0,0 +1,100 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package buffer
+
+import "github.com/byte-mug/brute/datatypes"
+import "github.com/byte-mug/brute/utils"
+import "github.com/byte-mug/brute/api"
+import "sync"
+import "testing"
+
+/* An in-memory StorageFacade, that can be made unwritable. */
+type mem struct{
+	utils.MergeUtil
+	lock sync.Mutex
+	m    map[string][]byte
+	fail bool
+}
+func newMem(f api.MergerFactory) *mem {
+	m := &mem{m:make(map[string][]byte)}
+	m.Merger = f
+	return m
+}
+func (m *mem) Submit(key, item []byte) bool {
+	m.lock.Lock(); defer m.lock.Unlock()
+	if m.fail { return false }
+	if o,ok := m.m[string(key)]; ok { item,_ = m.Merge(o,item) }
+	m.m[string(key)] = item
+	return true
+}
+func (m *mem) Obtain(key []byte) ([]byte,bool,bool) {
+	m.lock.Lock(); defer m.lock.Unlock()
+	item,ok := m.m[string(key)]
+	return item,ok,true
+}
+func (m *mem) Stream(f func(key, item []byte)) {}
+func (m *mem) len() int {
+	m.lock.Lock(); defer m.lock.Unlock()
+	return len(m.m)
+}
+
+func get(t *testing.T, b *Buffer, key string) string {
+	v,ok := datatypes.LWW_Decode(b.Obtain([]byte(key)))
+	if !ok { t.Fatalf("%q: not found",key) }
+	return string(v)
+}
+
+func TestCoalesce(t *testing.T) {
+	m := newMem(datatypes.LWW_Factory)
+	b := &Buffer{Api:m,MaxKeys:2}
+	b.Merger = datatypes.LWW_Factory
+	b.Submit([]byte("a"),datatypes.LWW_Put([]byte("1")))
+	b.Submit([]byte("a"),datatypes.LWW_Put([]byte("2")))
+	if m.len()!=0 { t.Fatal("written before flush") }
+	if v := get(t,b,"a"); v!="2" { t.Fatalf("got %q",v) }
+	
+	/* The second key reaches MaxKeys. */
+	b.Submit([]byte("b"),datatypes.LWW_Put([]byte("1")))
+	if m.len()!=2 { t.Fatalf("%d keys written, want 2",m.len()) }
+	if v := get(t,b,"a"); v!="2" { t.Fatalf("got %q",v) }
+}
+
+func TestFailedFlush(t *testing.T) {
+	m := newMem(datatypes.LWW_Factory)
+	failed := 0
+	b := &Buffer{Api:m,MaxKeys:2,Failed:func(n int){ failed += n }}
+	b.Merger = datatypes.LWW_Factory
+	m.fail = true
+	if !b.Submit([]byte("a"),datatypes.LWW_Put([]byte("1"))) { t.Fatal("submit failed") }
+	
+	/* Triggers a flush, that fails: the item is buffered nonetheless. */
+	if !b.Submit([]byte("b"),datatypes.LWW_Put([]byte("1"))) { t.Fatal("submit reported the failed flush") }
+	if failed!=2 { t.Fatalf("Failed reported %d items, want 2",failed) }
+	if v := get(t,b,"b"); v!="1" { t.Fatalf("got %q",v) }
+	if b.Close() { t.Fatal("close succeeded on an unwritable store") }
+	
+	m.fail = false
+	if !b.Flush() { t.Fatal("flush failed") }
+	if m.len()!=2 { t.Fatalf("%d keys written, want 2",m.len()) }
+}
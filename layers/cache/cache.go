@@ -0,0 +1,190 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package cache
+
+import "github.com/byte-mug/brute/utils"
+import "github.com/byte-mug/brute/api"
+import "container/list"
+import "sync"
+import "sync/atomic"
+
+/* The default value for Cache.MaxSize (64 MiB). */
+const DefaultMaxSize = 64<<20
+
+type entry struct{
+	key   string
+	item  []byte
+	valid bool
+	
+	/* Items submitted while the entry was loading. */
+	pend  [][]byte
+	
+	elem  *list.Element
+}
+func (e *entry) size() int { return len(e.key)+len(e.item) }
+
+func clone(b []byte) []byte {
+	return append(make([]byte,0,len(b)),b...)
+}
+
+type Stats struct{
+	Hits    uint64
+	Misses  uint64
+	Entries int
+	
+	/* The sum of the sizes of all cached keys and items, in bytes. */
+	Size    int
+}
+
+/*
+A read-through LRU cache in front of any StorageFacade.
+
+As items are merged commutatively, a .Submit() does not invalidate a cached
+item, but merges the submitted item into it. This keeps the cache coherent,
+as long as all writes pass through the Cache.
+*/
+type Cache struct{
+	utils.MergeUtil
+	Api     api.StorageFacade
+	
+	/*
+	Upper bound for the sum of the sizes of all cached keys and items, in bytes.
+	
+	If 0, DefaultMaxSize is used.
+	*/
+	MaxSize int
+	
+	lock    sync.Mutex
+	lru     list.List
+	entries map[string]*entry
+	size    int
+	
+	hits,misses uint64
+}
+func (c *Cache) maxSize() int {
+	if c.MaxSize<=0 { return DefaultMaxSize }
+	return c.MaxSize
+}
+func (c *Cache) insert(key []byte) *entry {
+	if c.entries==nil { c.entries = make(map[string]*entry) }
+	e := &entry{key:string(key)}
+	e.elem = c.lru.PushFront(e)
+	c.entries[e.key] = e
+	c.size += e.size()
+	return e
+}
+func (c *Cache) remove(e *entry) {
+	c.lru.Remove(e.elem)
+	delete(c.entries,e.key)
+	c.size -= e.size()
+}
+func (c *Cache) evict() {
+	max := c.maxSize()
+	for c.size>max {
+		back := c.lru.Back()
+		if back==nil { break }
+		c.remove(back.Value.(*entry))
+	}
+}
+func (c *Cache) setItem(e *entry, item []byte) {
+	c.size -= e.size()
+	e.item = item
+	c.size += e.size()
+	c.evict()
+}
+
+func (c *Cache) Submit(key, item []byte) (ok bool) {
+	ok = c.Api.Submit(key,item)
+	if !ok { return }
+	c.lock.Lock(); defer c.lock.Unlock()
+	e := c.entries[string(key)]
+	if e==nil { return }
+	if e.valid {
+		r,_ := c.Merge(e.item,clone(item))
+		c.setItem(e,r)
+	} else {
+		e.pend = append(e.pend,clone(item))
+	}
+	return
+}
+func (c *Cache) Obtain(key []byte) (item []byte,ok,readable bool) {
+	c.lock.Lock()
+	e := c.entries[string(key)]
+	if e!=nil && e.valid {
+		c.lru.MoveToFront(e.elem)
+		item = e.item
+		c.lock.Unlock()
+		atomic.AddUint64(&c.hits,1)
+		return item,true,true
+	}
+	if e==nil { e = c.insert(key) }
+	c.lock.Unlock()
+	atomic.AddUint64(&c.misses,1)
+	
+	item,ok,readable = c.Api.Obtain(key)
+	
+	c.lock.Lock(); defer c.lock.Unlock()
+	if c.entries[string(key)]!=e || e.valid {
+		/* The entry has been evicted or loaded concurrently. */
+		return
+	}
+	if !readable {
+		c.remove(e)
+		return
+	}
+	items := e.pend
+	if ok { items = append([][]byte{clone(item)},items...) }
+	if len(items)==0 {
+		c.remove(e)
+		return
+	}
+	item,_ = c.Merge(items...)
+	ok = true
+	e.valid = true
+	e.pend = nil
+	c.setItem(e,item)
+	return
+}
+func (c *Cache) Stream(f func(key, item []byte)) {
+	c.Api.Stream(f)
+}
+
+/* Drops the cached item for the supplied key, if any. */
+func (c *Cache) Invalidate(key []byte) {
+	c.lock.Lock(); defer c.lock.Unlock()
+	if e := c.entries[string(key)]; e!=nil { c.remove(e) }
+}
+
+/* Returns the hit/miss statistics and the current occupancy of the cache. */
+func (c *Cache) Stats() Stats {
+	c.lock.Lock(); defer c.lock.Unlock()
+	return Stats{
+		Hits:    atomic.LoadUint64(&c.hits),
+		Misses:  atomic.LoadUint64(&c.misses),
+		Entries: len(c.entries),
+		Size:    c.size,
+	}
+}
+
+var _ api.StorageFacade = (*Cache)(nil)
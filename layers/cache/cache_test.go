@@ -0,0 +1,133 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package cache
+
+import "github.com/byte-mug/brute/datatypes"
+import "github.com/byte-mug/brute/utils"
+import "github.com/byte-mug/brute/api"
+import "fmt"
+import "sync"
+import "testing"
+import "time"
+
+/* An in-memory StorageFacade, counting the Obtains. If set, Obtain waits for 'block'. */
+type mem struct{
+	utils.MergeUtil
+	lock  sync.Mutex
+	m     map[string][]byte
+	gets  int
+	block chan struct{}
+}
+func newMem(f api.MergerFactory) *mem {
+	m := &mem{m:make(map[string][]byte)}
+	m.Merger = f
+	return m
+}
+func (m *mem) Submit(key, item []byte) bool {
+	m.lock.Lock(); defer m.lock.Unlock()
+	if o,ok := m.m[string(key)]; ok { item,_ = m.Merge(o,item) }
+	m.m[string(key)] = item
+	return true
+}
+func (m *mem) Obtain(key []byte) ([]byte,bool,bool) {
+	m.lock.Lock()
+	m.gets++
+	item,ok := m.m[string(key)]
+	block := m.block
+	m.lock.Unlock()
+	if block!=nil { <-block }
+	return item,ok,true
+}
+func (m *mem) Stream(f func(key, item []byte)) {
+	m.lock.Lock(); defer m.lock.Unlock()
+	for k,v := range m.m { f([]byte(k),v) }
+}
+
+func newCache(max int) (*Cache,*mem) {
+	m := newMem(datatypes.LWW_Factory)
+	c := &Cache{Api:m,MaxSize:max}
+	c.Merger = datatypes.LWW_Factory
+	return c,m
+}
+
+func get(c *Cache, key string) string {
+	v,_ := datatypes.LWW_Decode(c.Obtain([]byte(key)))
+	return string(v)
+}
+
+func TestCache(t *testing.T) {
+	c,m := newCache(1000)
+	c.Submit([]byte("a"),datatypes.LWW_Put([]byte("1")))
+	if get(c,"a")!="1" || get(c,"a")!="1" || m.gets!=1 { t.Fatalf("%d reads of the backend, want 1",m.gets) }
+	
+	/* A Submit is merged into the cached item. */
+	time.Sleep(time.Millisecond)
+	c.Submit([]byte("a"),datatypes.LWW_Put([]byte("2")))
+	if v := get(c,"a"); v!="2" || m.gets!=1 { t.Fatalf("got %q after %d reads, want 2 after 1",v,m.gets) }
+	
+	/* Absent keys are not cached. */
+	for i := 0; i<2; i++ {
+		if _,ok,_ := c.Obtain([]byte("x")); ok { t.Fatal("found an absent key") }
+	}
+	if st := c.Stats(); st.Hits!=2 || st.Misses!=3 || st.Entries!=1 { t.Fatalf("%+v",st) }
+	
+	c.Invalidate([]byte("a"))
+	if get(c,"a")!="2" || m.gets!=4 { t.Fatalf("%d reads of the backend, want 4",m.gets) }
+}
+
+func TestEvict(t *testing.T) {
+	c,m := newCache(200)
+	for i := 0; i<20; i++ {
+		key := fmt.Sprint("k",i)
+		c.Submit([]byte(key),datatypes.LWW_Put([]byte("xxxxxxxx")))
+		get(c,key)
+		get(c,"k0") /* Keeps k0 recently used. */
+	}
+	st := c.Stats()
+	if st.Size>200 || st.Entries==20 { t.Fatalf("%+v exceeds the limit",st) }
+	gets := m.gets
+	if get(c,"k0")!="xxxxxxxx" || m.gets!=gets { t.Fatal("the most recently used item was evicted") }
+	if get(c,"k1")!="xxxxxxxx" || m.gets!=gets+1 { t.Fatal("the least recently used item was not evicted") }
+}
+
+/* Submits, that happen while the item is being loaded, are not lost. */
+func TestSubmitWhileLoading(t *testing.T) {
+	c,m := newCache(1000)
+	c.Submit([]byte("a"),datatypes.LWW_Put([]byte("1")))
+	m.block = make(chan struct{})
+	done := make(chan string)
+	go func(){ done <- get(c,"a") }()
+	for {
+		m.lock.Lock()
+		n := m.gets
+		m.lock.Unlock()
+		if n==1 { break }
+		time.Sleep(time.Millisecond)
+	}
+	c.Submit([]byte("a"),datatypes.LWW_Put([]byte("2")))
+	close(m.block)
+	if v := <-done; v!="2" { t.Fatalf("got %q, want 2",v) }
+	m.block = nil
+	if v := get(c,"a"); v!="2" || m.gets!=1 { t.Fatalf("got %q after %d reads, want 2 after 1",v,m.gets) }
+}
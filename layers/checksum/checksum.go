@@ -0,0 +1,97 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package checksum
+
+import "github.com/byte-mug/brute/layers/codec"
+import "encoding/binary"
+import "hash/crc32"
+import "errors"
+
+/*
+An item in a checksum envelope looks like this:
+
+	0xc1 | 0x80 | crc32c(item) (4 bytes, big endian) | item
+
+The byte 0xc1 is never used by msgpack, so plain msgpack items can be told
+apart. The second byte tells the envelope apart from the headers of other
+layers, such as layers/compress.
+*/
+const (
+	magic   = 0xc1
+	tag     = 0x80
+	envSize = 6
+)
+
+var ErrCorrupt = errors.New("checksum: corrupt item")
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+/* Returns true, if the item is in a checksum envelope. */
+func Enveloped(item []byte) bool {
+	return len(item)>=envSize && item[0]==magic && item[1]==tag
+}
+
+/*
+Checksum wraps items in an envelope carrying a CRC-32C of the item, so that
+corrupt items are detected before they are merged or replicated.
+
+Checksum implements codec.Codec, so it is used as follows:
+
+	backend.Merger = codec.Merger(checksum,datatypes.LWW_Factory)
+	facade := &codec.Facade{Codec:checksum,Api:backend}
+
+A corrupt item is treated as empty by the Merger, so that submitting a healthy
+copy of the item repairs it.
+*/
+type Checksum struct{
+	/*
+	If true, items without an envelope are considered corrupt. Otherwise they
+	are passed through unverified, which allows existing stores to be migrated.
+	*/
+	Strict bool
+}
+
+func (c *Checksum) Encode(item []byte) ([]byte,error) {
+	buf := make([]byte,envSize,envSize+len(item))
+	buf[0] = magic
+	buf[1] = tag
+	binary.BigEndian.PutUint32(buf[2:],crc32.Checksum(item,castagnoli))
+	return append(buf,item...),nil
+}
+func (c *Checksum) Decode(item []byte) ([]byte,error) {
+	if !Enveloped(item) {
+		if c.Strict { return nil,ErrCorrupt }
+		return item,nil
+	}
+	if binary.BigEndian.Uint32(item[2:])!=crc32.Checksum(item[envSize:],castagnoli) {
+		return nil,ErrCorrupt
+	}
+	return item[envSize:],nil
+}
+
+/* Items, that fail the verification, are corrupt. */
+func (c *Checksum) Corrupt(err error) bool { return err==ErrCorrupt }
+
+var _ codec.Codec = (*Checksum)(nil)
+var _ codec.Corrupter = (*Checksum)(nil)
@@ -0,0 +1,127 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package checksum
+
+import "github.com/byte-mug/brute/layers/codec"
+import "github.com/byte-mug/brute/datatypes"
+import "github.com/byte-mug/brute/utils"
+import "github.com/byte-mug/brute/api"
+import "sort"
+import "sync"
+import "testing"
+
+/* An in-memory StorageFacade. */
+type mem struct{
+	utils.MergeUtil
+	lock sync.Mutex
+	m    map[string][]byte
+}
+func newMem(f api.MergerFactory) *mem {
+	m := &mem{m:make(map[string][]byte)}
+	m.Merger = f
+	return m
+}
+func (m *mem) Submit(key, item []byte) bool {
+	m.lock.Lock(); defer m.lock.Unlock()
+	if o,ok := m.m[string(key)]; ok { item,_ = m.Merge(o,item) }
+	m.m[string(key)] = item
+	return true
+}
+func (m *mem) Obtain(key []byte) ([]byte,bool,bool) {
+	m.lock.Lock(); defer m.lock.Unlock()
+	item,ok := m.m[string(key)]
+	return item,ok,true
+}
+func (m *mem) Stream(f func(key, item []byte)) {
+	m.lock.Lock()
+	keys := make([]string,0,len(m.m))
+	for k := range m.m { keys = append(keys,k) }
+	m.lock.Unlock()
+	sort.Strings(keys)
+	for _,k := range keys {
+		item,_,_ := m.Obtain([]byte(k))
+		f([]byte(k),item)
+	}
+}
+/* Flips a bit of the stored item. */
+func (m *mem) corrupt(key string) {
+	m.lock.Lock(); defer m.lock.Unlock()
+	item := append([]byte(nil),m.m[key]...)
+	item[len(item)-1] ^= 0x10
+	m.m[key] = item
+}
+
+func newStore(c *Checksum) (*mem,*codec.Facade) {
+	raw := newMem(codec.Merger(c,datatypes.LWW_Factory))
+	return raw,&codec.Facade{Codec:c,Api:raw}
+}
+
+func get(f api.StorageFacade, key string) string {
+	v,_ := datatypes.LWW_Decode(f.Obtain([]byte(key)))
+	return string(v)
+}
+
+func TestChecksum(t *testing.T) {
+	c := new(Checksum)
+	raw,f := newStore(c)
+	f.Submit([]byte("a"),datatypes.LWW_Put([]byte("v")))
+	item,_,_ := raw.Obtain([]byte("a"))
+	if !Enveloped(item) { t.Fatal("the item is not enveloped") }
+	if get(f,"a")!="v" { t.Fatal("round trip failed") }
+	
+	raw.corrupt("a")
+	if _,_,readable := f.Obtain([]byte("a")); readable { t.Fatal("a corrupt item was read") }
+	
+	/* A corrupt item is replaced by the next Submit. */
+	f.Submit([]byte("a"),datatypes.LWW_Put([]byte("w")))
+	if get(f,"a")!="w" { t.Fatal("a corrupt item was not replaced") }
+	
+	/* Plain items pass, unless Strict is set. */
+	plain := datatypes.LWW_Put([]byte("p"))
+	if dec,err := c.Decode(plain); err!=nil || string(dec)!=string(plain) { t.Fatalf("plain item: %v",err) }
+	strict := &Checksum{Strict:true}
+	if _,err := strict.Decode(plain); err!=ErrCorrupt { t.Fatalf("plain item in strict mode: %v",err) }
+}
+
+func TestScrub(t *testing.T) {
+	c := new(Checksum)
+	raw,f := newStore(c)
+	_,peer := newStore(c)
+	for _,k := range []string{"a","b","c"} {
+		item := datatypes.LWW_Put([]byte(k))
+		f.Submit([]byte(k),item)
+		peer.Submit([]byte(k),item)
+	}
+	raw.Submit([]byte("d"),datatypes.LWW_Put([]byte("d")))
+	raw.corrupt("b")
+	
+	var reported []string
+	s := &Scrubber{Checksum:c,Raw:raw,Report:func(key []byte, repaired bool){ reported = append(reported,string(key)) }}
+	if st := s.Scrub(); st.Checked!=4 || st.Unverified!=1 || st.Corrupt!=1 || st.Repaired!=0 { t.Fatalf("%+v",st) }
+	s.Peer = peer
+	if st := s.Scrub(); st.Corrupt!=1 || st.Repaired!=1 { t.Fatalf("%+v",st) }
+	if st := s.Scrub(); st.Corrupt!=0 { t.Fatalf("%+v after the repair",st) }
+	if len(reported)!=2 || reported[0]!="b" { t.Fatalf("reported %v",reported) }
+	if get(f,"b")!="b" { t.Fatal("the repaired item is wrong") }
+}
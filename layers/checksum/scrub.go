@@ -0,0 +1,96 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package checksum
+
+import "github.com/byte-mug/brute/api"
+
+type Stats struct{
+	Checked    int
+	
+	/* Items without an envelope (only if Checksum.Strict is false). */
+	Unverified int
+	
+	Corrupt    int
+	Repaired   int
+}
+
+/*
+A Scrubber walks a store and verifies the checksums of all items, reporting
+(and optionally repairing) corrupt entries.
+*/
+type Scrubber struct{
+	Checksum *Checksum
+	
+	/*
+	The store underneath the checksum facade, holding the enveloped items.
+	It must use codec.Merger(Checksum,...) as its MergerFactory.
+	*/
+	Raw      api.StorageFacade
+	
+	/*
+	Optional: a peer to fetch healthy copies of corrupt items from, such as an
+	httpi.Client of another node. It must yield plain items.
+	*/
+	Peer     api.StorageFacade
+	
+	/* Optional: called for every corrupt key, after the repair attempt. */
+	Report   func(key []byte, repaired bool)
+}
+
+func (s *Scrubber) verify(item []byte) bool {
+	_,err := s.Checksum.Decode(item)
+	return err==nil
+}
+func (s *Scrubber) repair(key []byte) bool {
+	if s.Peer==nil { return false }
+	item,ok,readable := s.Peer.Obtain(key)
+	if !(ok&&readable) { return false }
+	item,err := s.Checksum.Encode(item)
+	if err!=nil { return false }
+	if !s.Raw.Submit(key,item) { return false }
+	item,ok,readable = s.Raw.Obtain(key)
+	return ok && readable && s.verify(item)
+}
+
+func (s *Scrubber) Scrub() (st Stats) {
+	var corrupt [][]byte
+	s.Raw.Stream(func(key, item []byte){
+		st.Checked++
+		if !Enveloped(item) && !s.Checksum.Strict {
+			st.Unverified++
+			return
+		}
+		if s.verify(item) { return }
+		corrupt = append(corrupt,append([]byte(nil),key...))
+	})
+	
+	/* Repairs are done after streaming, as the store may not allow writes during a Stream. */
+	for _,key := range corrupt {
+		st.Corrupt++
+		ok := s.repair(key)
+		if ok { st.Repaired++ }
+		if s.Report!=nil { s.Report(key,ok) }
+	}
+	return
+}
@@ -0,0 +1,161 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package codec
+
+import "github.com/byte-mug/brute/api"
+
+/*
+A Codec transforms items between their plain representation, as understood by
+the Merger, and their stored representation.
+*/
+type Codec interface{
+	/* Transforms a plain item into its stored representation. */
+	Encode(item []byte) ([]byte,error)
+	
+	/* Transforms a stored item back into its plain representation. */
+	Decode(item []byte) ([]byte,error)
+}
+
+/*
+Optionally implemented by Codecs, whose stored representation can become
+outdated, eg. after a key rotation.
+*/
+type Staler interface{
+	/* Returns true, if the stored item should be encoded anew. */
+	Stale(item []byte) bool
+}
+
+/*
+Optionally implemented by Codecs, to tell a corrupt stored item apart from
+one, that can not be decoded for now, eg. as its key or dictionary is missing.
+*/
+type Corrupter interface{
+	/* Returns true, if the error returned by Decode means, the item is corrupt. */
+	Corrupt(err error) bool
+}
+
+/* Returns true, if the stored item can not be decoded, but is not corrupt. */
+func refused(c Codec, err error) bool {
+	if err==nil { return false }
+	cr,ok := c.(Corrupter)
+	return !ok || !cr.Corrupt(err)
+}
+
+type merger struct{
+	codec   Codec
+	inner   api.Merger
+	init    []byte
+	stale   bool
+	refused bool
+}
+func (m *merger) Init(item []byte) {
+	m.init = item
+	plain,err := m.codec.Decode(item)
+	m.refused = refused(m.codec,err)
+	if err!=nil { plain = nil }
+	m.stale = false
+	if st,ok := m.codec.(Staler); ok && err==nil { m.stale = st.Stale(item) }
+	m.inner.Init(plain)
+}
+func (m *merger) Merge(item []byte) {
+	if m.refused { return }
+	plain,err := m.codec.Decode(item)
+	if err!=nil { return }
+	m.inner.Merge(plain)
+}
+func (m *merger) Changed() bool {
+	if m.refused { return false }
+	return m.inner.Changed() || m.stale
+}
+func (m *merger) Result() []byte {
+	if !m.Changed() { return m.init }
+	item,err := m.codec.Encode(m.inner.Result())
+	if err!=nil { return m.init }
+	return item
+}
+func (m *merger) Cleanup() {
+	m.init = nil
+	m.inner.Cleanup()
+}
+
+/*
+Wraps a MergerFactory, so that the Mergers operate on stored items: items are
+decoded before handing them to the inner Merger, and the result is encoded.
+
+Items, that can not be decoded, are ignored (for .Merge()). A stored item (for
+.Init()), that can not be decoded, is only treated as empty, if the Codec
+implements Corrupter and reports it corrupt, so that a healthy copy repairs it.
+Otherwise, eg. if its key or dictionary is missing, the merge is refused: it
+reports no change, and the stored item is kept. If the merge did not change anything, the stored item
+supplied to .Init() is returned as is, unless the Codec implements Staler and
+reports it stale: then it is encoded anew.
+
+The StorageFacade underneath a Facade must use this MergerFactory.
+*/
+func Merger(c Codec, m api.MergerFactory) api.MergerFactory {
+	return func() api.Merger {
+		return &merger{codec:c,inner:m()}
+	}
+}
+
+/*
+A StorageFacade, that encodes items before submitting them to the underlying
+StorageFacade and decodes them after obtaining them.
+*/
+type Facade struct{
+	Codec Codec
+	Api   api.StorageFacade
+}
+/*
+Returns false, if the stored item can not be decoded, but is not corrupt (see
+Merger), as the item would not be merged.
+*/
+func (f *Facade) Submit(key, item []byte) (ok bool) {
+	if old,ok,readable := f.Api.Obtain(key); ok && readable {
+		if _,err := f.Codec.Decode(old); refused(f.Codec,err) { return false }
+	}
+	item,err := f.Codec.Encode(item)
+	if err!=nil { return false }
+	return f.Api.Submit(key,item)
+}
+
+/* If the stored item can not be decoded, readable is false. */
+func (f *Facade) Obtain(key []byte) (item []byte,ok,readable bool) {
+	item,ok,readable = f.Api.Obtain(key)
+	if !(ok&&readable) { return }
+	item,err := f.Codec.Decode(item)
+	if err!=nil { return nil,false,false }
+	return
+}
+
+/* Items, that can not be decoded, are skipped. */
+func (f *Facade) Stream(fn func(key, item []byte)) {
+	f.Api.Stream(func(key, item []byte){
+		item,err := f.Codec.Decode(item)
+		if err!=nil { return }
+		fn(key,item)
+	})
+}
+
+var _ api.StorageFacade = (*Facade)(nil)
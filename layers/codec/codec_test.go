@@ -0,0 +1,114 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package codec
+
+import "github.com/byte-mug/brute/datatypes"
+import "github.com/byte-mug/brute/utils"
+import "github.com/byte-mug/brute/api"
+import "errors"
+import "sync"
+import "testing"
+
+var (
+	errKey     = errors.New("test: unknown key")
+	errCorrupt = errors.New("test: corrupt")
+)
+
+/* Prefixes items with a key id; items with an unknown id can not be decoded. */
+type keyed struct{
+	id   byte
+	keys map[byte]bool
+}
+func (k *keyed) Encode(item []byte) ([]byte,error) {
+	return append([]byte{k.id},item...),nil
+}
+func (k *keyed) Decode(item []byte) ([]byte,error) {
+	if len(item)==0 { return nil,errCorrupt }
+	if !k.keys[item[0]] { return nil,errKey }
+	return item[1:],nil
+}
+func (k *keyed) Corrupt(err error) bool { return err==errCorrupt }
+
+/* An in-memory StorageFacade. */
+type mem struct{
+	utils.MergeUtil
+	lock sync.Mutex
+	m    map[string][]byte
+}
+func newMem(f api.MergerFactory) *mem {
+	m := &mem{m:make(map[string][]byte)}
+	m.Merger = f
+	return m
+}
+func (m *mem) Submit(key, item []byte) bool {
+	m.lock.Lock(); defer m.lock.Unlock()
+	if o,ok := m.m[string(key)]; ok {
+		var ch bool
+		item,ch = m.Merge(o,item)
+		if !ch { return true }
+	}
+	m.m[string(key)] = item
+	return true
+}
+func (m *mem) Obtain(key []byte) ([]byte,bool,bool) {
+	m.lock.Lock(); defer m.lock.Unlock()
+	item,ok := m.m[string(key)]
+	return item,ok,true
+}
+func (m *mem) Stream(f func(key, item []byte)) {}
+
+func TestRefuseUndecodable(t *testing.T) {
+	k := &keyed{id:1,keys:map[byte]bool{1:true}}
+	raw := newMem(Merger(k,datatypes.Table_Factory))
+	f := &Facade{Codec:k,Api:raw}
+	key := []byte("row")
+	if !f.Submit(key,datatypes.Table_Put(datatypes.Row{"a":1,"b":2})) { t.Fatal("submit failed") }
+	stored,_,_ := raw.Obtain(key)
+	
+	/* Rotate to key 2 and lose key 1. */
+	k.id = 2
+	k.keys = map[byte]bool{2:true}
+	if f.Submit(key,datatypes.Table_Put(datatypes.Row{"a":3})) { t.Fatal("submit into an undecodable item succeeded") }
+	
+	/* Submits bypassing the Facade, eg. from replication, are not merged either. */
+	enc,_ := k.Encode(datatypes.Table_Put(datatypes.Row{"a":3}))
+	raw.Submit(key,enc)
+	if cur,_,_ := raw.Obtain(key); string(cur)!=string(stored) { t.Fatal("undecodable item overwritten") }
+	
+	/* Once the key is back, the item is intact. */
+	k.keys[1] = true
+	row,ok := datatypes.Table_Decode(f.Obtain(key))
+	if !ok || len(row)!=2 { t.Fatalf("got %v",row) }
+}
+
+func TestReplaceCorrupt(t *testing.T) {
+	k := &keyed{id:1,keys:map[byte]bool{1:true}}
+	raw := newMem(Merger(k,datatypes.LWW_Factory))
+	f := &Facade{Codec:k,Api:raw}
+	key := []byte("k")
+	raw.m[string(key)] = []byte{}
+	if !f.Submit(key,datatypes.LWW_Put([]byte("v"))) { t.Fatal("submit failed") }
+	v,ok := datatypes.LWW_Decode(f.Obtain(key))
+	if !ok || string(v)!="v" { t.Fatalf("got %q",v) }
+}
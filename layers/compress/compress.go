@@ -0,0 +1,182 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package compress
+
+import "github.com/byte-mug/brute/layers/codec"
+import "compress/flate"
+import "encoding/binary"
+import "io/ioutil"
+import "bytes"
+import "errors"
+import "sync"
+
+/*
+A compressed item starts with the byte 0xc1, which is never used by msgpack, so
+that uncompressed msgpack items can be told apart and are passed through as is.
+
+	0xc1 | cStored  | item
+	0xc1 | cDeflate | deflate(item)
+	0xc1 | cDict    | dictionary id (4 bytes, big endian) | deflate(item,dictionary)
+*/
+const magic = 0xc1
+
+const (
+	cStored  = 0
+	cDeflate = 1
+	cDict    = 2
+)
+
+/* The default value for Compressor.MinSize. */
+const DefaultMinSize = 64
+
+var (
+	ErrCodec  = errors.New("compress: unknown codec")
+	ErrNoDict = errors.New("compress: unknown dictionary")
+)
+
+type dictionary struct{
+	data []byte
+	pool sync.Pool
+}
+
+/*
+A Compressor compresses items using DEFLATE, optionally with a preset
+dictionary. Dictionaries are specific to a keyspace, so every keyspace should
+have its own Compressor.
+
+The Compressor implements codec.Codec, so it is used as follows:
+
+	backend.Merger = codec.Merger(compressor,datatypes.Table_Factory)
+	facade := &codec.Facade{Codec:compressor,Api:backend}
+*/
+type Compressor struct{
+	/*
+	The DEFLATE compression level. If 0, flate.BestCompression is used, as the
+	faster levels tend to store small items uncompressed.
+	*/
+	Level   int
+	
+	/* Items smaller than this are stored uncompressed. If 0, DefaultMinSize is used. */
+	MinSize int
+	
+	pool    sync.Pool
+	
+	lock    sync.RWMutex
+	dicts   map[uint32]*dictionary
+	dict    uint32
+	hasDict bool
+}
+func (c *Compressor) level() int {
+	if c.Level==0 { return flate.BestCompression }
+	return c.Level
+}
+func (c *Compressor) minSize() int {
+	if c.MinSize<=0 { return DefaultMinSize }
+	return c.MinSize
+}
+
+/* Adds a dictionary. Items compressed with it can be decompressed from now on. */
+func (c *Compressor) AddDictionary(id uint32, dict []byte) {
+	c.lock.Lock(); defer c.lock.Unlock()
+	if c.dicts==nil { c.dicts = make(map[uint32]*dictionary) }
+	c.dicts[id] = &dictionary{data:dict}
+}
+
+/* Makes the dictionary 'id' the one, that new items are compressed with. */
+func (c *Compressor) UseDictionary(id uint32) error {
+	c.lock.Lock(); defer c.lock.Unlock()
+	if _,ok := c.dicts[id]; !ok { return ErrNoDict }
+	c.dict = id
+	c.hasDict = true
+	return nil
+}
+
+func (c *Compressor) deflate(buf *bytes.Buffer, pool *sync.Pool, dict []byte, item []byte) error {
+	var w *flate.Writer
+	var err error
+	if i := pool.Get(); i!=nil {
+		w = i.(*flate.Writer)
+		w.Reset(buf)
+	} else if w,err = flate.NewWriterDict(buf,c.level(),dict); err!=nil {
+		return err
+	}
+	defer pool.Put(w)
+	if _,err = w.Write(item); err!=nil { return err }
+	return w.Close()
+}
+
+func (c *Compressor) Encode(item []byte) ([]byte,error) {
+	if len(item)<c.minSize() {
+		if len(item)>0 && item[0]==magic { return append([]byte{magic,cStored},item...),nil }
+		return item,nil
+	}
+	c.lock.RLock()
+	var d *dictionary
+	var id uint32
+	if c.hasDict {
+		id = c.dict
+		d = c.dicts[id]
+	}
+	c.lock.RUnlock()
+	
+	buf := new(bytes.Buffer)
+	var err error
+	if d!=nil {
+		buf.Write([]byte{magic,cDict,0,0,0,0})
+		binary.BigEndian.PutUint32(buf.Bytes()[2:],id)
+		err = c.deflate(buf,&d.pool,d.data,item)
+	} else {
+		buf.Write([]byte{magic,cDeflate})
+		err = c.deflate(buf,&c.pool,nil,item)
+	}
+	if err!=nil { return nil,err }
+	if buf.Len()>=len(item) && item[0]!=magic { return item,nil }
+	return buf.Bytes(),nil
+}
+func (c *Compressor) Decode(item []byte) ([]byte,error) {
+	if len(item)<2 || item[0]!=magic { return item,nil }
+	switch item[1] {
+	case cStored:
+		return item[2:],nil
+	case cDeflate:
+		return ioutil.ReadAll(flate.NewReader(bytes.NewReader(item[2:])))
+	case cDict:
+		if len(item)<6 { return nil,ErrNoDict }
+		c.lock.RLock()
+		d := c.dicts[binary.BigEndian.Uint32(item[2:])]
+		c.lock.RUnlock()
+		if d==nil { return nil,ErrNoDict }
+		return ioutil.ReadAll(flate.NewReaderDict(bytes.NewReader(item[6:]),d.data))
+	}
+	return nil,ErrCodec
+}
+
+/*
+Items, that can not be inflated, are corrupt; items, whose codec or dictionary
+is unknown, are not.
+*/
+func (c *Compressor) Corrupt(err error) bool { return err!=ErrNoDict && err!=ErrCodec }
+
+var _ codec.Codec = (*Compressor)(nil)
+var _ codec.Corrupter = (*Compressor)(nil)
@@ -0,0 +1,138 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package compress
+
+import "github.com/byte-mug/brute/layers/codec"
+import "github.com/byte-mug/brute/datatypes"
+import "github.com/byte-mug/brute/utils"
+import "github.com/byte-mug/brute/api"
+import "bytes"
+import "crypto/rand"
+import "fmt"
+import "sort"
+import "sync"
+import "testing"
+
+/* An in-memory StorageFacade. */
+type mem struct{
+	utils.MergeUtil
+	lock sync.Mutex
+	m    map[string][]byte
+}
+func newMem(f api.MergerFactory) *mem {
+	m := &mem{m:make(map[string][]byte)}
+	m.Merger = f
+	return m
+}
+func (m *mem) Submit(key, item []byte) bool {
+	m.lock.Lock(); defer m.lock.Unlock()
+	if o,ok := m.m[string(key)]; ok { item,_ = m.Merge(o,item) }
+	m.m[string(key)] = item
+	return true
+}
+func (m *mem) Obtain(key []byte) ([]byte,bool,bool) {
+	m.lock.Lock(); defer m.lock.Unlock()
+	item,ok := m.m[string(key)]
+	return item,ok,true
+}
+func (m *mem) Stream(f func(key, item []byte)) {
+	m.lock.Lock()
+	keys := make([]string,0,len(m.m))
+	for k := range m.m { keys = append(keys,k) }
+	m.lock.Unlock()
+	sort.Strings(keys)
+	for _,k := range keys {
+		item,_,_ := m.Obtain([]byte(k))
+		f([]byte(k),item)
+	}
+}
+func (m *mem) size() (n int) {
+	m.lock.Lock(); defer m.lock.Unlock()
+	for _,v := range m.m { n += len(v) }
+	return
+}
+
+func person(i int) datatypes.Row {
+	return datatypes.Row{"firstname":"John","lastname":"Doe","street":fmt.Sprint("Main Street ",i),"city":"Springfield","zip":10000+i}
+}
+
+func TestRoundTrip(t *testing.T) {
+	c := new(Compressor)
+	noise := make([]byte,200)
+	rand.Read(noise)
+	for _,item := range [][]byte{
+		nil,
+		[]byte("short"),
+		{magic,cDeflate},
+		bytes.Repeat([]byte("abcd"),100),
+		append([]byte{magic},bytes.Repeat([]byte("x"),100)...),
+		noise,
+	} {
+		enc,err := c.Encode(item)
+		if err!=nil { t.Fatal(err) }
+		dec,err := c.Decode(enc)
+		if err!=nil || !bytes.Equal(dec,item) { t.Fatalf("%x: got %x %v",item,dec,err) }
+		if len(item)>=DefaultMinSize && len(enc)>len(item)+2 { t.Errorf("%d bytes grew to %d",len(item),len(enc)) }
+	}
+	if enc,_ := c.Encode(bytes.Repeat([]byte("abcd"),100)); len(enc)>=100 { t.Errorf("compressed to %d bytes",len(enc)) }
+}
+
+func TestDictionary(t *testing.T) {
+	plain := newMem(datatypes.Table_Factory)
+	for i := 0; i<100; i++ { plain.Submit([]byte(fmt.Sprint(i)),datatypes.Table_Put(person(i))) }
+	dict := Train(plain,1024,1<<20)
+	if len(dict)==0 || len(dict)>1024 { t.Fatalf("dictionary of %d bytes",len(dict)) }
+	
+	sizes := make([]int,2)
+	for i,useDict := range []bool{false,true} {
+		c := &Compressor{MinSize:1}
+		if useDict {
+			c.AddDictionary(7,dict)
+			if err := c.UseDictionary(7); err!=nil { t.Fatal(err) }
+		}
+		raw := newMem(codec.Merger(c,datatypes.Table_Factory))
+		f := &codec.Facade{Codec:c,Api:raw}
+		plain.Stream(func(key, item []byte){ f.Submit(key,item) })
+		f.Submit([]byte("5"),datatypes.Table_Put(datatypes.Row{"zip":99}))
+		row,ok := datatypes.Table_Decode(f.Obtain([]byte("5")))
+		if !ok || row["firstname"]!="John" || fmt.Sprint(row["zip"])!="99" { t.Fatalf("got %v",row) }
+		sizes[i] = raw.size()
+	}
+	if sizes[1]>=sizes[0] { t.Errorf("%d bytes with a dictionary, %d without",sizes[1],sizes[0]) }
+}
+
+func TestUnknownDictionary(t *testing.T) {
+	c := &Compressor{MinSize:1}
+	c.AddDictionary(1,[]byte("John Doe Springfield"))
+	c.UseDictionary(1)
+	enc,_ := c.Encode(datatypes.Table_Put(person(1)))
+	
+	other := new(Compressor)
+	if err := other.UseDictionary(1); err!=ErrNoDict { t.Fatalf("UseDictionary of an unknown dictionary: %v",err) }
+	_,err := other.Decode(enc)
+	if err!=ErrNoDict || other.Corrupt(err) { t.Fatalf("%v must not be corrupt",err) }
+	
+	_,err = c.Decode(enc[:len(enc)/2])
+	if err==nil || !c.Corrupt(err) { t.Fatalf("a truncated item: %v",err) }
+}
@@ -0,0 +1,78 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package compress
+
+import "github.com/byte-mug/brute/api"
+import "bytes"
+import "sort"
+
+/* The length of the substrings, a dictionary is assembled from. */
+const gramSize = 8
+
+/* The maximum dictionary size, DEFLATE can make use of. */
+const MaxDictSize = 32<<10
+
+/*
+Trains a dictionary of at most 'size' bytes from the items of 'src', inspecting
+at most 'sample' bytes of items. 'src' must yield uncompressed items.
+
+Substrings, that occur frequently, are placed into the dictionary, the most
+frequent ones at the end, as DEFLATE encodes short distances more cheaply.
+*/
+func Train(src api.StorageFacade, size, sample int) []byte {
+	if size>MaxDictSize { size = MaxDictSize }
+	counts := make(map[string]int)
+	src.Stream(func(key, item []byte){
+		if sample<=0 { return }
+		sample -= len(item)
+		for i := 0; i+gramSize<=len(item); i++ {
+			counts[string(item[i:i+gramSize])]++
+		}
+	})
+	
+	grams := make([]string,0,len(counts))
+	for g,n := range counts {
+		if n>1 { grams = append(grams,g) }
+	}
+	sort.Slice(grams,func(i, j int) bool {
+		if counts[grams[i]]!=counts[grams[j]] { return counts[grams[i]]>counts[grams[j]] }
+		return grams[i]<grams[j]
+	})
+	
+	var picked [][]byte
+	var dict []byte
+	for _,g := range grams {
+		if len(dict)+gramSize>size { break }
+		if bytes.Contains(dict,[]byte(g)) { continue }
+		picked = append(picked,[]byte(g))
+		dict = append(dict,g...)
+	}
+	
+	/* Reverse the order, so that the most frequent substrings are at the end. */
+	dict = dict[:0]
+	for i := len(picked)-1; i>=0; i-- {
+		dict = append(dict,picked[i]...)
+	}
+	return dict
+}
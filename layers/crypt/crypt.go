@@ -0,0 +1,143 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package crypt
+
+import "github.com/byte-mug/brute/layers/codec"
+import "crypto/aes"
+import "crypto/cipher"
+import "crypto/rand"
+import "encoding/binary"
+import "errors"
+import "io"
+import "sync"
+
+/*
+An encrypted item looks like this:
+
+	version (1 byte) | key id (4 bytes, big endian) | nonce | ciphertext+tag
+*/
+const version = 1
+
+const headerSize = 1+4
+
+var (
+	ErrNoKey      = errors.New("crypt: unknown key id")
+	ErrNoPrimary  = errors.New("crypt: no primary key")
+	ErrMalformed  = errors.New("crypt: malformed item")
+)
+
+/*
+A Keyring holds AES-GCM keys, identified by a key id. New items are encrypted
+with the primary key; items are decrypted with the key named in their header.
+
+To rotate keys, add the new key and make it the primary key. Items are
+re-encrypted with the primary key whenever they are merged; .Rewrap()
+re-encrypts all remaining ones, after which the old keys can be removed.
+
+The Keyring implements codec.Codec, so it is used as follows:
+
+	backend.Merger = codec.Merger(keyring,datatypes.LWW_Factory)
+	facade := &codec.Facade{Codec:keyring,Api:backend}
+*/
+type Keyring struct{
+	lock    sync.RWMutex
+	keys    map[uint32]cipher.AEAD
+	primary uint32
+	hasPrim bool
+}
+
+/* Adds an AES-128, AES-192 or AES-256 key. */
+func (k *Keyring) Add(id uint32, key []byte) error {
+	blk,err := aes.NewCipher(key)
+	if err!=nil { return err }
+	aead,err := cipher.NewGCM(blk)
+	if err!=nil { return err }
+	k.lock.Lock(); defer k.lock.Unlock()
+	if k.keys==nil { k.keys = make(map[uint32]cipher.AEAD) }
+	k.keys[id] = aead
+	return nil
+}
+
+/* Makes the key 'id' the key, that new items are encrypted with. */
+func (k *Keyring) SetPrimary(id uint32) error {
+	k.lock.Lock(); defer k.lock.Unlock()
+	if _,ok := k.keys[id]; !ok { return ErrNoKey }
+	k.primary = id
+	k.hasPrim = true
+	return nil
+}
+
+/*
+Removes the key 'id'. Items encrypted with it can no longer be decrypted; merges
+into them are refused (see codec.Merger).
+*/
+func (k *Keyring) Remove(id uint32) {
+	k.lock.Lock(); defer k.lock.Unlock()
+	delete(k.keys,id)
+	if k.primary==id { k.hasPrim = false }
+}
+
+/* Returns the id of the key, the item has been encrypted with. */
+func KeyID(item []byte) (id uint32,ok bool) {
+	if len(item)<headerSize || item[0]!=version { return 0,false }
+	return binary.BigEndian.Uint32(item[1:]),true
+}
+
+/* Returns true, if the item is not encrypted with the primary key. */
+func (k *Keyring) Stale(item []byte) bool {
+	id,ok := KeyID(item)
+	if !ok { return false }
+	k.lock.RLock(); defer k.lock.RUnlock()
+	return k.hasPrim && id!=k.primary
+}
+
+func (k *Keyring) Encode(item []byte) ([]byte,error) {
+	k.lock.RLock()
+	id,has := k.primary,k.hasPrim
+	aead := k.keys[id]
+	k.lock.RUnlock()
+	if !has { return nil,ErrNoPrimary }
+	
+	ns := aead.NonceSize()
+	buf := make([]byte,headerSize+ns,headerSize+ns+len(item)+aead.Overhead())
+	buf[0] = version
+	binary.BigEndian.PutUint32(buf[1:],id)
+	nonce := buf[headerSize:]
+	if _,err := io.ReadFull(rand.Reader,nonce); err!=nil { return nil,err }
+	return aead.Seal(buf,nonce,item,buf[:headerSize]),nil
+}
+func (k *Keyring) Decode(item []byte) ([]byte,error) {
+	id,ok := KeyID(item)
+	if !ok { return nil,ErrMalformed }
+	k.lock.RLock()
+	aead := k.keys[id]
+	k.lock.RUnlock()
+	if aead==nil { return nil,ErrNoKey }
+	ns := aead.NonceSize()
+	if len(item)<headerSize+ns { return nil,ErrMalformed }
+	return aead.Open(nil,item[headerSize:headerSize+ns],item[headerSize+ns:],item[:headerSize])
+}
+
+var _ codec.Codec = (*Keyring)(nil)
+var _ codec.Staler = (*Keyring)(nil)
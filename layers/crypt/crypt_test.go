@@ -0,0 +1,142 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package crypt
+
+import "github.com/byte-mug/brute/layers/codec"
+import "github.com/byte-mug/brute/datatypes"
+import "github.com/byte-mug/brute/utils"
+import "github.com/byte-mug/brute/api"
+import "bytes"
+import "fmt"
+import "sort"
+import "sync"
+import "testing"
+
+/* An in-memory StorageFacade, that stores merged items only if they changed. */
+type mem struct{
+	utils.MergeUtil
+	lock sync.Mutex
+	m    map[string][]byte
+}
+func newMem(f api.MergerFactory) *mem {
+	m := &mem{m:make(map[string][]byte)}
+	m.Merger = f
+	return m
+}
+func (m *mem) Submit(key, item []byte) bool {
+	m.lock.Lock(); defer m.lock.Unlock()
+	if o,ok := m.m[string(key)]; ok {
+		var ch bool
+		item,ch = m.Merge(o,item)
+		if !ch { return true }
+	}
+	m.m[string(key)] = item
+	return true
+}
+func (m *mem) Obtain(key []byte) ([]byte,bool,bool) {
+	m.lock.Lock(); defer m.lock.Unlock()
+	item,ok := m.m[string(key)]
+	return item,ok,true
+}
+func (m *mem) Stream(f func(key, item []byte)) {
+	m.lock.Lock()
+	keys := make([]string,0,len(m.m))
+	for k := range m.m { keys = append(keys,k) }
+	m.lock.Unlock()
+	sort.Strings(keys)
+	for _,k := range keys {
+		item,_,_ := m.Obtain([]byte(k))
+		f([]byte(k),item)
+	}
+}
+
+func newKeyring(t *testing.T, id uint32) *Keyring {
+	k := new(Keyring)
+	if err := k.Add(id,bytes.Repeat([]byte{byte(id)},32)); err!=nil { t.Fatal(err) }
+	if err := k.SetPrimary(id); err!=nil { t.Fatal(err) }
+	return k
+}
+
+func get(f api.StorageFacade, key string) string {
+	v,_ := datatypes.LWW_Decode(f.Obtain([]byte(key)))
+	return string(v)
+}
+
+func TestEncrypt(t *testing.T) {
+	k := newKeyring(t,1)
+	raw := newMem(codec.Merger(k,datatypes.LWW_Factory))
+	f := &codec.Facade{Codec:k,Api:raw}
+	f.Submit([]byte("a"),datatypes.LWW_Put([]byte("secret")))
+	item,_,_ := raw.Obtain([]byte("a"))
+	if bytes.Contains(item,[]byte("secret")) { t.Fatal("the item is stored in plain text") }
+	if id,ok := KeyID(item); !ok || id!=1 { t.Fatalf("key id %d %v, want 1",id,ok) }
+	if v := get(f,"a"); v!="secret" { t.Fatalf("got %q",v) }
+	
+	/* Tampering is detected. */
+	item[len(item)-1] ^= 1
+	if _,_,readable := f.Obtain([]byte("a")); readable { t.Fatal("a tampered item was decrypted") }
+	
+	if err := k.SetPrimary(9); err!=ErrNoKey { t.Fatalf("SetPrimary of an unknown key: %v",err) }
+	if _,err := new(Keyring).Encode([]byte("x")); err!=ErrNoPrimary { t.Fatalf("Encode without a primary key: %v",err) }
+}
+
+func TestRotate(t *testing.T) {
+	k := newKeyring(t,1)
+	raw := newMem(codec.Merger(k,datatypes.LWW_Factory))
+	f := &codec.Facade{Codec:k,Api:raw}
+	for i := 0; i<10; i++ { f.Submit([]byte(fmt.Sprint(i)),datatypes.LWW_Put([]byte("v"))) }
+	
+	k.Add(2,bytes.Repeat([]byte{2},16))
+	k.SetPrimary(2)
+	
+	/* A merge re-encrypts the item with the primary key. */
+	f.Submit([]byte("0"),datatypes.LWW_Put([]byte("w")))
+	if item,_,_ := raw.Obtain([]byte("0")); k.Stale(item) { t.Fatal("a merged item is still stale") }
+	
+	if st := k.Rewrap(raw); st.Checked!=10 || st.Rewrapped!=9 || st.Failed!=0 { t.Fatalf("%+v",st) }
+	if st := k.Rewrap(raw); st.Rewrapped!=0 { t.Fatalf("%+v: rewrapped twice",st) }
+	k.Remove(1)
+	for i := 0; i<10; i++ {
+		want := "v"
+		if i==0 { want = "w" }
+		if v := get(f,fmt.Sprint(i)); v!=want { t.Fatalf("key %d: got %q, want %q",i,v,want) }
+	}
+}
+
+/* Items encrypted with a removed key are neither readable nor overwritten. */
+func TestRemovedKey(t *testing.T) {
+	k := newKeyring(t,1)
+	raw := newMem(codec.Merger(k,datatypes.LWW_Factory))
+	f := &codec.Facade{Codec:k,Api:raw}
+	f.Submit([]byte("a"),datatypes.LWW_Put([]byte("v")))
+	stored,_,_ := raw.Obtain([]byte("a"))
+	
+	k.Add(2,bytes.Repeat([]byte{2},16))
+	k.SetPrimary(2)
+	k.Remove(1)
+	if _,_,readable := f.Obtain([]byte("a")); readable { t.Fatal("decrypted with a removed key") }
+	if f.Submit([]byte("a"),datatypes.LWW_Put([]byte("w"))) { t.Fatal("merged into an item with a removed key") }
+	if st := k.Rewrap(raw); st.Failed!=1 { t.Fatalf("%+v",st) }
+	if item,_,_ := raw.Obtain([]byte("a")); !bytes.Equal(item,stored) { t.Fatal("an item with a removed key was overwritten") }
+}
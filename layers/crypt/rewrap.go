@@ -0,0 +1,67 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package crypt
+
+import "github.com/byte-mug/brute/api"
+
+type RewrapStats struct{
+	/* Items examined. */
+	Checked   int
+	
+	/* Items re-encrypted with the primary key. */
+	Rewrapped int
+	
+	/* Stale items, that could not be re-encrypted, eg. as their key is missing. */
+	Failed    int
+}
+
+/*
+Re-encrypts all items of raw, that are not encrypted with the primary key.
+'raw' is the StorageFacade underneath the codec.Facade; its Merger must be
+codec.Merger(k,...), which re-encrypts stale items, when they are submitted
+again.
+
+Once Failed is 0, keys other than the primary one can be removed safely.
+*/
+func (k *Keyring) Rewrap(raw api.StorageFacade) (st RewrapStats) {
+	var stale [][]byte
+	raw.Stream(func(key, item []byte){
+		st.Checked++
+		if k.Stale(item) { stale = append(stale,append([]byte(nil),key...)) }
+	})
+	for _,key := range stale {
+		item,ok,_ := raw.Obtain(key)
+		if !ok || !k.Stale(item) { continue }
+		if _,err := k.Decode(item); err!=nil || !raw.Submit(key,item) {
+			st.Failed++
+			continue
+		}
+		if item,ok,_ = raw.Obtain(key); ok && !k.Stale(item) {
+			st.Rewrapped++
+		} else {
+			st.Failed++
+		}
+	}
+	return
+}
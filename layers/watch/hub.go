@@ -0,0 +1,189 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package watch
+
+import "bytes"
+import "sync"
+
+/* The default value for Hub.History. */
+const DefaultHistory = 1024
+
+/* Event.Lost is set to LostUnknown, if an unknown number of events has been lost. */
+const LostUnknown = ^uint64(0)
+
+type Event struct{
+	/* A sequence number, increasing with every event published by a Hub. */
+	Seq  uint64
+	
+	Key  []byte
+	
+	/* The merged item, after the change. */
+	Item []byte
+	
+	/*
+	The number of events, that have been dropped for this subscription right
+	before this one, as the subscriber did not keep up.
+	*/
+	Lost uint64
+}
+
+type Subscription struct{
+	/* The events. Closed after .Close(). */
+	C      <-chan Event
+	
+	/* The sequence number of the last event published before the subscription. */
+	Seq    uint64
+	
+	c      chan Event
+	hub    *Hub
+	key    []byte
+	prefix bool
+	lost   uint64
+}
+func (s *Subscription) matches(key []byte) bool {
+	if s.prefix { return bytes.HasPrefix(key,s.key) }
+	return bytes.Equal(key,s.key)
+}
+
+/* Cancels the subscription. */
+func (s *Subscription) Close() {
+	h := s.hub
+	h.lock.Lock(); defer h.lock.Unlock()
+	if _,ok := h.subs[s]; !ok { return }
+	delete(h.subs,s)
+	close(s.c)
+}
+
+/*
+A Hub delivers change events to subscribers.
+
+Every subscription has a bounded buffer. If it is full, events are dropped for
+that subscription rather than blocking the writer, and the next event, that
+is delivered, carries the number of dropped events in Event.Lost.
+*/
+type Hub struct{
+	/*
+	The number of recent events, that are kept for .Resume(). If 0,
+	DefaultHistory is used; if negative, no events are kept.
+	*/
+	History int
+	
+	lock sync.Mutex
+	subs map[*Subscription]struct{}
+	seq  uint64
+	
+	/* A ring buffer of the recent events. */
+	hist []Event
+	hpos int
+}
+func (h *Hub) subscribe(key []byte, prefix bool, buffer int) *Subscription {
+	h.lock.Lock(); defer h.lock.Unlock()
+	return h.subscribeLocked(key,prefix,buffer)
+}
+func (h *Hub) subscribeLocked(key []byte, prefix bool, buffer int) *Subscription {
+	if buffer<1 { buffer = 1 }
+	c := make(chan Event,buffer)
+	s := &Subscription{C:c,Seq:h.seq,c:c,hub:h,key:append([]byte(nil),key...),prefix:prefix}
+	if h.subs==nil { h.subs = make(map[*Subscription]struct{}) }
+	h.subs[s] = struct{}{}
+	return s
+}
+
+/* Subscribes to the changes of a single key. */
+func (h *Hub) Subscribe(key []byte, buffer int) *Subscription {
+	return h.subscribe(key,false,buffer)
+}
+
+/* Subscribes to the changes of all keys starting with 'prefix'. */
+func (h *Hub) SubscribePrefix(prefix []byte, buffer int) *Subscription {
+	return h.subscribe(prefix,true,buffer)
+}
+
+/*
+Subscribes to the changes of a key (or of all keys starting with it, if prefix
+is true) and returns the recent matching events with a sequence number greater
+than 'since' as backlog.
+
+If complete is false, the history does not reach back to 'since', so that an
+unknown number of events is missing from the backlog.
+*/
+func (h *Hub) Resume(key []byte, prefix bool, since uint64, buffer int) (s *Subscription, backlog []Event, complete bool) {
+	h.lock.Lock(); defer h.lock.Unlock()
+	s = h.subscribeLocked(key,prefix,buffer)
+	complete = since<=h.seq
+	if since==h.seq { return }
+	n := len(h.hist)
+	for i := 0; i<n; i++ {
+		ev := h.hist[(h.hpos+i)%n]
+		if i==0 && ev.Seq>since+1 { complete = false }
+		if ev.Seq>since && s.matches(ev.Key) { backlog = append(backlog,ev) }
+	}
+	if n==0 { complete = false }
+	return
+}
+
+func (h *Hub) history() int {
+	if h.History==0 { return DefaultHistory }
+	return h.History
+}
+func (h *Hub) record(ev Event) {
+	if len(h.hist)<h.history() {
+		h.hist = append(h.hist,ev)
+		return
+	}
+	h.hist[h.hpos] = ev
+	h.hpos = (h.hpos+1)%len(h.hist)
+}
+
+/* Returns the sequence number of the last event published. */
+func (h *Hub) Seq() uint64 {
+	h.lock.Lock(); defer h.lock.Unlock()
+	return h.seq
+}
+
+/* Publishes a change. This function never blocks on subscribers. */
+func (h *Hub) Publish(key, item []byte) {
+	h.lock.Lock(); defer h.lock.Unlock()
+	h.seq++
+	ev := Event{Seq:h.seq}
+	if h.history()>0 {
+		ev.Key = append(make([]byte,0,len(key)),key...)
+		ev.Item = append(make([]byte,0,len(item)),item...)
+		h.record(ev)
+	}
+	for s := range h.subs {
+		if !s.matches(key) { continue }
+		if ev.Key==nil {
+			ev.Key = append(make([]byte,0,len(key)),key...)
+			ev.Item = append(make([]byte,0,len(item)),item...)
+		}
+		ev.Lost = s.lost
+		select {
+		case s.c <- ev:
+			s.lost = 0
+		default:
+			s.lost++
+		}
+	}
+}
@@ -0,0 +1,75 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package watch
+
+import "github.com/byte-mug/brute/utils"
+import "github.com/byte-mug/brute/api"
+import "github.com/dgryski/go-farm"
+import "sync"
+
+const nLocks = 64
+
+/*
+A StorageFacade, that publishes an event to the Hub, whenever a .Submit()
+actually changes the stored item.
+
+All writes, local ones as well as those of the replicator (eg. by using the
+Watch as Syncer.Api or Updater.Store), must pass through the Watch.
+*/
+type Watch struct{
+	utils.MergeUtil
+	Api   api.StorageFacade
+	Hub   *Hub
+	
+	locks [nLocks]sync.Mutex
+}
+func (w *Watch) Submit(key, item []byte) (ok bool) {
+	lock := &w.locks[farm.Hash32(key)%nLocks]
+	lock.Lock(); defer lock.Unlock()
+	
+	old,found,readable := w.Api.Obtain(key)
+	if !readable {
+		/* We can't tell, whether the item changed, so report the stored one. */
+		ok = w.Api.Submit(key,item)
+		if !ok { return }
+		if old,found,readable = w.Api.Obtain(key); found && readable {
+			w.Hub.Publish(key,old)
+		}
+		return
+	}
+	merged,changed := item,true
+	if found { merged,changed = w.Merge(old,item) }
+	
+	ok = w.Api.Submit(key,item)
+	if ok && changed { w.Hub.Publish(key,merged) }
+	return
+}
+func (w *Watch) Obtain(key []byte) (item []byte,ok,readable bool) {
+	return w.Api.Obtain(key)
+}
+func (w *Watch) Stream(f func(key, item []byte)) {
+	w.Api.Stream(f)
+}
+
+var _ api.StorageFacade = (*Watch)(nil)
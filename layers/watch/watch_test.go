@@ -0,0 +1,104 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package watch
+
+import "github.com/byte-mug/brute/datatypes"
+import "github.com/byte-mug/brute/utils"
+import "github.com/byte-mug/brute/api"
+import "sync"
+import "testing"
+
+/* An in-memory StorageFacade. */
+type mem struct{
+	utils.MergeUtil
+	lock sync.Mutex
+	m    map[string][]byte
+}
+func newMem(f api.MergerFactory) *mem {
+	m := &mem{m:make(map[string][]byte)}
+	m.Merger = f
+	return m
+}
+func (m *mem) Submit(key, item []byte) bool {
+	m.lock.Lock(); defer m.lock.Unlock()
+	if o,ok := m.m[string(key)]; ok { item,_ = m.Merge(o,item) }
+	m.m[string(key)] = item
+	return true
+}
+func (m *mem) Obtain(key []byte) ([]byte,bool,bool) {
+	m.lock.Lock(); defer m.lock.Unlock()
+	item,ok := m.m[string(key)]
+	return item,ok,true
+}
+func (m *mem) Stream(f func(key, item []byte)) {}
+
+func newWatch(h *Hub) *Watch {
+	w := &Watch{Api:newMem(datatypes.LWW_Factory),Hub:h}
+	w.Merger = datatypes.LWW_Factory
+	return w
+}
+
+func TestSubscribe(t *testing.T) {
+	w := newWatch(new(Hub))
+	pre := w.Hub.SubscribePrefix([]byte("a"),2)
+	key := w.Hub.Subscribe([]byte("b"),8)
+	item := datatypes.LWW_Put([]byte("1"))
+	w.Submit([]byte("a1"),item)
+	w.Submit([]byte("a1"),item) /* Unchanged: no event. */
+	w.Submit([]byte("a2"),datatypes.LWW_Put(nil))
+	w.Submit([]byte("a3"),datatypes.LWW_Put(nil)) /* Dropped: the buffer is full. */
+	w.Submit([]byte("b"),item)
+	
+	if ev := <-pre.C; string(ev.Key)!="a1" || ev.Lost!=0 { t.Fatalf("got %+v",ev) }
+	if ev := <-pre.C; string(ev.Key)!="a2" { t.Fatalf("got %+v",ev) }
+	w.Submit([]byte("a4"),datatypes.LWW_Put(nil))
+	if ev := <-pre.C; string(ev.Key)!="a4" || ev.Lost!=1 { t.Fatalf("got %+v",ev) }
+	if ev := <-key.C; string(ev.Key)!="b" || ev.Seq!=4 { t.Fatalf("got %+v",ev) }
+	
+	pre.Close()
+	pre.Close()
+	if _,ok := <-pre.C; ok { t.Fatal("channel not closed") }
+}
+
+func TestResume(t *testing.T) {
+	h := new(Hub) /* DefaultHistory */
+	for _,k := range []string{"a1","b1","a2"} { h.Publish([]byte(k),nil) }
+	
+	s,backlog,complete := h.Resume([]byte("a"),true,1,8)
+	defer s.Close()
+	if !complete || len(backlog)!=1 || backlog[0].Seq!=3 { t.Fatalf("got %v %+v",complete,backlog) }
+	if s.Seq!=3 { t.Fatalf("Seq %d, want 3",s.Seq) }
+	
+	h = &Hub{History:2}
+	for _,k := range []string{"a1","a2","a3"} { h.Publish([]byte(k),nil) }
+	s,backlog,complete = h.Resume([]byte("a"),true,0,8)
+	defer s.Close()
+	if complete || len(backlog)!=2 { t.Fatalf("got %v %+v",complete,backlog) }
+	
+	h = &Hub{History:-1}
+	h.Publish([]byte("a1"),nil)
+	s,_,complete = h.Resume([]byte("a"),true,0,8)
+	defer s.Close()
+	if complete { t.Fatal("complete without history") }
+}
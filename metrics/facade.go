@@ -0,0 +1,134 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package metrics
+
+import "github.com/byte-mug/brute/api"
+import "sync"
+import "time"
+
+/*
+A StorageFacade, that records counters and latencies of the operations on the
+underlying StorageFacade.
+
+The series are resolved on first use, so Registry and Name must not be changed
+afterwards.
+*/
+type Facade struct{
+	Api      api.StorageFacade
+	Registry *Registry
+	
+	/* The value of the "store" label. */
+	Name     string
+	
+	once     sync.Once
+	series   facadeSeries
+}
+
+type facadeSeries struct{
+	submitSeconds, obtainSeconds *Histogram
+	submitOK, submitFail *Counter
+	obtainFound, obtainNotFound, obtainUnreadable *Counter
+	stream, streamItems *Counter
+}
+func (f *Facade) get() *facadeSeries {
+	f.once.Do(func(){
+		r,s := f.Registry,&f.series
+		s.submitSeconds = r.Histogram("brute_storage_submit_seconds","Latency of Submit operations.",nil,"store",f.Name)
+		s.obtainSeconds = r.Histogram("brute_storage_obtain_seconds","Latency of Obtain operations.",nil,"store",f.Name)
+		const sh = "Number of Submit operations."
+		s.submitOK = r.Counter("brute_storage_submit_total",sh,"store",f.Name,"result","ok")
+		s.submitFail = r.Counter("brute_storage_submit_total",sh,"store",f.Name,"result","fail")
+		const oh = "Number of Obtain operations."
+		s.obtainFound = r.Counter("brute_storage_obtain_total",oh,"store",f.Name,"result","found")
+		s.obtainNotFound = r.Counter("brute_storage_obtain_total",oh,"store",f.Name,"result","notfound")
+		s.obtainUnreadable = r.Counter("brute_storage_obtain_total",oh,"store",f.Name,"result","unreadable")
+		s.stream = r.Counter("brute_storage_stream_total","Number of Stream operations.","store",f.Name)
+		s.streamItems = r.Counter("brute_storage_stream_items_total","Number of items streamed.","store",f.Name)
+	})
+	return &f.series
+}
+func (f *Facade) Submit(key, item []byte) (ok bool) {
+	s := f.get()
+	begin := time.Now()
+	ok = f.Api.Submit(key,item)
+	s.submitSeconds.Observe(time.Since(begin).Seconds())
+	if ok {
+		s.submitOK.Inc()
+	} else {
+		s.submitFail.Inc()
+	}
+	return
+}
+func (f *Facade) Obtain(key []byte) (item []byte,ok,readable bool) {
+	s := f.get()
+	begin := time.Now()
+	item,ok,readable = f.Api.Obtain(key)
+	s.obtainSeconds.Observe(time.Since(begin).Seconds())
+	if !readable {
+		s.obtainUnreadable.Inc()
+	} else if !ok {
+		s.obtainNotFound.Inc()
+	} else {
+		s.obtainFound.Inc()
+	}
+	return
+}
+func (f *Facade) Stream(fn func(key, item []byte)) {
+	s := f.get()
+	var n uint64
+	f.Api.Stream(func(key, item []byte){
+		n++
+		fn(key,item)
+	})
+	s.stream.Inc()
+	s.streamItems.Add(n)
+}
+
+var _ api.StorageFacade = (*Facade)(nil)
+
+type merger struct{
+	api.Merger
+	changed, unchanged *Counter
+}
+func (m *merger) Result() []byte {
+	if m.Merger.Changed() {
+		m.changed.Inc()
+	} else {
+		m.unchanged.Inc()
+	}
+	return m.Merger.Result()
+}
+
+/*
+Wraps a MergerFactory, counting the merges, that did or did not change the
+stored item, labeled with store=name.
+*/
+func Merger(r *Registry, name string, f api.MergerFactory) api.MergerFactory {
+	const help = "Number of merges by whether they changed the stored item."
+	changed := r.Counter("brute_merge_total",help,"store",name,"changed","true")
+	unchanged := r.Counter("brute_merge_total",help,"store",name,"changed","false")
+	return func() api.Merger {
+		return &merger{f(),changed,unchanged}
+	}
+}
@@ -0,0 +1,91 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package metrics
+
+import "net/http"
+import "strconv"
+import "sync"
+import "time"
+
+type statusWriter struct{
+	http.ResponseWriter
+	code int
+}
+func (s *statusWriter) WriteHeader(code int) {
+	if s.code==0 { s.code = code }
+	s.ResponseWriter.WriteHeader(code)
+}
+func (s *statusWriter) Write(p []byte) (int,error) {
+	if s.code==0 { s.code = 200 }
+	return s.ResponseWriter.Write(p)
+}
+func (s *statusWriter) Flush() {
+	if f,ok := s.ResponseWriter.(http.Flusher); ok { f.Flush() }
+}
+
+type httpSeries struct{
+	seconds  *Histogram
+	requests *Counter
+}
+
+/* The series of a handler, resolved once per method and status code. */
+type httpCache struct{
+	r      *Registry
+	name   string
+	lock   sync.RWMutex
+	series map[string]*httpSeries
+}
+func (c *httpCache) get(method string, code int) *httpSeries {
+	k := method+" "+strconv.Itoa(code)
+	c.lock.RLock()
+	s := c.series[k]
+	c.lock.RUnlock()
+	if s!=nil { return s }
+	c.lock.Lock(); defer c.lock.Unlock()
+	if s = c.series[k]; s!=nil { return s }
+	s = &httpSeries{
+		c.r.Histogram("brute_http_request_seconds","Latency of HTTP requests.",nil,"handler",c.name,"method",method),
+		c.r.Counter("brute_http_requests_total","Number of HTTP requests.","handler",c.name,"method",method,"code",strconv.Itoa(code)),
+	}
+	c.series[k] = s
+	return s
+}
+
+/*
+Wraps a http.Handler (such as the httprouter.Router, both httpi Servers are
+registered to), counting requests by method and status code and recording
+their latencies, labeled with handler=name.
+*/
+func Handler(r *Registry, name string, h http.Handler) http.Handler {
+	c := &httpCache{r:r,name:name,series:make(map[string]*httpSeries)}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		begin := time.Now()
+		sw := &statusWriter{ResponseWriter:w}
+		h.ServeHTTP(sw,req)
+		if sw.code==0 { sw.code = 200 }
+		s := c.get(req.Method,sw.code)
+		s.seconds.Observe(time.Since(begin).Seconds())
+		s.requests.Inc()
+	})
+}
@@ -0,0 +1,204 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package metrics
+
+import "net/http"
+import "bufio"
+import "fmt"
+import "io"
+import "math"
+import "sort"
+import "strconv"
+import "strings"
+import "sync"
+import "sync/atomic"
+
+/* Default histogram buckets for latencies, in seconds. */
+var DefBuckets = []float64{.0005,.001,.0025,.005,.01,.025,.05,.1,.25,.5,1,2.5,5,10}
+
+type family struct{
+	name, help, typ string
+	series map[string]interface{}
+}
+
+/*
+A Registry holds counters and histograms and renders them in the Prometheus
+text exposition format. It implements http.Handler, so it can be served on an
+endpoint directly.
+*/
+type Registry struct{
+	lock     sync.Mutex
+	families map[string]*family
+}
+
+/* Renders label pairs ("k1","v1","k2","v2",...) in the exposition format. */
+func formatLabels(labels []string, extra ...string) string {
+	labels = append(append([]string(nil),labels...),extra...)
+	if len(labels)==0 { return "" }
+	var sb strings.Builder
+	sb.WriteByte('{')
+	for i := 0; i+1<len(labels); i+=2 {
+		if i>0 { sb.WriteByte(',') }
+		sb.WriteString(labels[i])
+		sb.WriteString(`="`)
+		sb.WriteString(strings.NewReplacer(`\`,`\\`,`"`,`\"`,"\n",`\n`).Replace(labels[i+1]))
+		sb.WriteByte('"')
+	}
+	sb.WriteByte('}')
+	return sb.String()
+}
+
+func (r *Registry) get(name, help, typ string, labels []string, mk func() interface{}) interface{} {
+	r.lock.Lock(); defer r.lock.Unlock()
+	if r.families==nil { r.families = make(map[string]*family) }
+	f := r.families[name]
+	if f==nil {
+		f = &family{name:name,help:help,typ:typ,series:make(map[string]interface{})}
+		r.families[name] = f
+	}
+	if f.typ!=typ { panic("metrics: "+name+" registered as "+f.typ) }
+	key := strings.Join(labels,"\x00")
+	m := f.series[key]
+	if m==nil {
+		m = mk()
+		f.series[key] = m
+	}
+	return m
+}
+
+type Counter struct{
+	labels []string
+	v      uint64
+}
+func (c *Counter) Add(n uint64) { atomic.AddUint64(&c.v,n) }
+func (c *Counter) Inc() { atomic.AddUint64(&c.v,1) }
+func (c *Counter) Value() uint64 { return atomic.LoadUint64(&c.v) }
+
+/*
+Returns the counter 'name' with the supplied label pairs ("k1","v1",...),
+creating it if nessesary.
+*/
+func (r *Registry) Counter(name, help string, labels ...string) *Counter {
+	return r.get(name,help,"counter",labels,func() interface{} {
+		return &Counter{labels:labels}
+	}).(*Counter)
+}
+
+type Histogram struct{
+	labels  []string
+	lock    sync.Mutex
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+func (h *Histogram) Observe(v float64) {
+	h.lock.Lock(); defer h.lock.Unlock()
+	i := sort.SearchFloat64s(h.buckets,v)
+	if i<len(h.counts) { h.counts[i]++ }
+	h.sum += v
+	h.count++
+}
+
+/*
+Returns the histogram 'name' with the supplied label pairs ("k1","v1",...),
+creating it if nessesary. If buckets is nil, DefBuckets are used.
+*/
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	if buckets==nil { buckets = DefBuckets }
+	return r.get(name,help,"histogram",labels,func() interface{} {
+		return &Histogram{labels:labels,buckets:buckets,counts:make([]uint64,len(buckets))}
+	}).(*Histogram)
+}
+
+func formatFloat(f float64) string {
+	if math.IsInf(f,1) { return "+Inf" }
+	return strconv.FormatFloat(f,'g',-1,64)
+}
+
+/* Writes all metrics in the Prometheus text exposition format. */
+func (r *Registry) WriteTo(w io.Writer) (int64,error) {
+	r.lock.Lock()
+	names := make([]string,0,len(r.families))
+	for name := range r.families { names = append(names,name) }
+	sort.Strings(names)
+	type snap struct{
+		f    *family
+		keys []string
+	}
+	snaps := make([]snap,len(names))
+	for i,name := range names {
+		f := r.families[name]
+		keys := make([]string,0,len(f.series))
+		for k := range f.series { keys = append(keys,k) }
+		sort.Strings(keys)
+		snaps[i] = snap{f,keys}
+	}
+	r.lock.Unlock()
+	
+	cw := &countWriter{w:bufio.NewWriter(w)}
+	for _,s := range snaps {
+		fmt.Fprintf(cw,"# HELP %s %s\n# TYPE %s %s\n",s.f.name,s.f.help,s.f.name,s.f.typ)
+		for _,k := range s.keys {
+			r.lock.Lock()
+			m := s.f.series[k]
+			r.lock.Unlock()
+			switch v := m.(type) {
+			case *Counter:
+				fmt.Fprintf(cw,"%s%s %d\n",s.f.name,formatLabels(v.labels),v.Value())
+			case *Histogram:
+				v.lock.Lock()
+				var cum uint64
+				for i,b := range v.buckets {
+					cum += v.counts[i]
+					fmt.Fprintf(cw,"%s_bucket%s %d\n",s.f.name,formatLabels(v.labels,"le",formatFloat(b)),cum)
+				}
+				fmt.Fprintf(cw,"%s_bucket%s %d\n",s.f.name,formatLabels(v.labels,"le","+Inf"),v.count)
+				fmt.Fprintf(cw,"%s_sum%s %s\n",s.f.name,formatLabels(v.labels),formatFloat(v.sum))
+				fmt.Fprintf(cw,"%s_count%s %d\n",s.f.name,formatLabels(v.labels),v.count)
+				v.lock.Unlock()
+			}
+		}
+	}
+	if cw.err!=nil { return cw.n,cw.err }
+	return cw.n,cw.w.Flush()
+}
+
+type countWriter struct{
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+func (c *countWriter) Write(p []byte) (int,error) {
+	if c.err!=nil { return 0,c.err }
+	n,err := c.w.Write(p)
+	c.n += int64(n)
+	c.err = err
+	return n,err
+}
+
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type","text/plain; version=0.0.4")
+	r.WriteTo(w)
+}
@@ -0,0 +1,121 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package metrics
+
+import "github.com/byte-mug/brute/datatypes"
+import "github.com/byte-mug/brute/utils"
+import "github.com/byte-mug/brute/api"
+import "bytes"
+import "errors"
+import "net/http"
+import "net/http/httptest"
+import "strings"
+import "sync"
+import "testing"
+import "time"
+
+/* An in-memory StorageFacade. */
+type mem struct{
+	utils.MergeUtil
+	lock sync.Mutex
+	m    map[string][]byte
+}
+func newMem(f api.MergerFactory) *mem {
+	m := &mem{m:make(map[string][]byte)}
+	m.Merger = f
+	return m
+}
+func (m *mem) Submit(key, item []byte) bool {
+	m.lock.Lock(); defer m.lock.Unlock()
+	if o,ok := m.m[string(key)]; ok { item,_ = m.Merge(o,item) }
+	m.m[string(key)] = item
+	return true
+}
+func (m *mem) Obtain(key []byte) ([]byte,bool,bool) {
+	m.lock.Lock(); defer m.lock.Unlock()
+	item,ok := m.m[string(key)]
+	return item,ok,true
+}
+func (m *mem) Stream(f func(key, item []byte)) {
+	m.lock.Lock(); defer m.lock.Unlock()
+	for k,v := range m.m { f([]byte(k),v) }
+}
+
+func expose(t *testing.T, r *Registry) string {
+	var buf bytes.Buffer
+	if _,err := r.WriteTo(&buf); err!=nil { t.Fatal(err) }
+	return buf.String()
+}
+func expect(t *testing.T, out string, lines ...string) {
+	for _,l := range lines {
+		if !strings.Contains(out,l+"\n") { t.Errorf("missing %q in:\n%s",l,out) }
+	}
+}
+
+func TestFacade(t *testing.T) {
+	r := new(Registry)
+	f := &Facade{Api:newMem(Merger(r,"kv",datatypes.LWW_Factory)),Registry:r,Name:"kv"}
+	f.Submit([]byte("a"),datatypes.LWW_Put([]byte("1")))
+	f.Submit([]byte("a"),datatypes.LWW_Put([]byte("2")))
+	f.Obtain([]byte("a"))
+	f.Obtain([]byte("b"))
+	f.Stream(func(key, item []byte){})
+	expect(t,expose(t,r),
+		`brute_storage_submit_total{store="kv",result="ok"} 2`,
+		`brute_storage_obtain_total{store="kv",result="found"} 1`,
+		`brute_storage_obtain_total{store="kv",result="notfound"} 1`,
+		`brute_storage_stream_items_total{store="kv"} 1`,
+		`brute_merge_total{store="kv",changed="true"} 1`,
+		`brute_storage_submit_seconds_count{store="kv"} 2`,
+	)
+}
+
+func TestHandler(t *testing.T) {
+	r := new(Registry)
+	h := Handler(r,"api",http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path=="/missing" { w.WriteHeader(404) }
+	}))
+	for _,p := range []string{"/","/","/missing"} {
+		h.ServeHTTP(httptest.NewRecorder(),httptest.NewRequest("GET",p,nil))
+	}
+	expect(t,expose(t,r),
+		`brute_http_requests_total{handler="api",method="GET",code="200"} 2`,
+		`brute_http_requests_total{handler="api",method="GET",code="404"} 1`,
+		`brute_http_request_seconds_count{handler="api",method="GET"} 3`,
+	)
+}
+
+func TestSyncHooks(t *testing.T) {
+	r := new(Registry)
+	s := &SyncHooks{Registry:r}
+	s.Synced("n1",5,time.Millisecond,nil)
+	s.Synced("n1",2,time.Millisecond,errors.New("down"))
+	s.Version("n2",time.Millisecond,nil)
+	expect(t,expose(t,r),
+		`brute_sync_total{node="n1",result="ok"} 1`,
+		`brute_sync_total{node="n1",result="fail"} 1`,
+		`brute_sync_items_total{node="n1"} 7`,
+		`brute_sync_version_total{node="n2",result="ok"} 1`,
+	)
+}
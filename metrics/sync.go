@@ -0,0 +1,82 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package metrics
+
+import "github.com/byte-mug/brute/replicator/httpi"
+import "sync"
+import "time"
+
+/*
+Implements httpi.SyncHooks, recording replication metrics per node.
+
+The series of a node are resolved on first use, so Registry must not be
+changed afterwards.
+*/
+type SyncHooks struct{
+	Registry *Registry
+	
+	lock     sync.RWMutex
+	nodes    map[string]*syncSeries
+}
+
+type syncSeries struct{
+	versionSeconds, syncSeconds *Histogram
+	versionOK, versionFail *Counter
+	syncOK, syncFail, syncItems *Counter
+}
+func (s *SyncHooks) get(node string) *syncSeries {
+	s.lock.RLock()
+	ns := s.nodes[node]
+	s.lock.RUnlock()
+	if ns!=nil { return ns }
+	s.lock.Lock(); defer s.lock.Unlock()
+	if ns = s.nodes[node]; ns!=nil { return ns }
+	r := s.Registry
+	ns = new(syncSeries)
+	ns.versionSeconds = r.Histogram("brute_sync_version_seconds","Latency of version queries.",nil,"node",node)
+	const vh = "Number of version queries."
+	ns.versionOK = r.Counter("brute_sync_version_total",vh,"node",node,"result","ok")
+	ns.versionFail = r.Counter("brute_sync_version_total",vh,"node",node,"result","fail")
+	ns.syncSeconds = r.Histogram("brute_sync_seconds","Duration of sync runs.",nil,"node",node)
+	const sh = "Number of sync runs."
+	ns.syncOK = r.Counter("brute_sync_total",sh,"node",node,"result","ok")
+	ns.syncFail = r.Counter("brute_sync_total",sh,"node",node,"result","fail")
+	ns.syncItems = r.Counter("brute_sync_items_total","Number of items received by sync runs.","node",node)
+	if s.nodes==nil { s.nodes = make(map[string]*syncSeries) }
+	s.nodes[node] = ns
+	return ns
+}
+func (s *SyncHooks) Version(node string, d time.Duration, err error) {
+	ns := s.get(node)
+	ns.versionSeconds.Observe(d.Seconds())
+	if err!=nil { ns.versionFail.Inc() } else { ns.versionOK.Inc() }
+}
+func (s *SyncHooks) Synced(node string, items int, d time.Duration, err error) {
+	ns := s.get(node)
+	ns.syncSeconds.Observe(d.Seconds())
+	if err!=nil { ns.syncFail.Inc() } else { ns.syncOK.Inc() }
+	ns.syncItems.Add(uint64(items))
+}
+
+var _ httpi.SyncHooks = (*SyncHooks)(nil)
@@ -0,0 +1,211 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package auth
+
+import "github.com/julienschmidt/httprouter"
+import "net/http"
+
+import "crypto/hmac"
+import "crypto/sha256"
+import "encoding/hex"
+import "bytes"
+import "io/ioutil"
+import "strconv"
+import "strings"
+import "sync"
+import "time"
+
+type Perm uint
+const (
+	Read Perm = 1<<iota
+	Write
+	Replicate
+	
+	All = Read|Write|Replicate
+)
+
+/* The default value for Authenticator.Window. */
+const DefaultWindow = 5*time.Minute
+
+/* The default value for Authenticator.MaxBody. */
+const DefaultMaxBody = 32<<20
+
+const (
+	hKey       = "X-Brute-Key"
+	hDate      = "X-Brute-Date"
+	hNonce     = "X-Brute-Nonce"
+	hSignature = "X-Brute-Signature"
+)
+
+type HMACKey struct{
+	Secret []byte
+	Perm   Perm
+}
+
+/*
+An Authenticator checks the credentials of incoming requests, that are either
+static bearer tokens or HMAC-signed requests (see HMAC).
+
+A nil *Authenticator allows every request.
+*/
+type Authenticator struct{
+	/* Static bearer tokens and their permissions. */
+	Tokens map[string]Perm
+	
+	/* HMAC keys by key id. */
+	Keys   map[string]HMACKey
+	
+	/*
+	Permissions of TLS clients by their identity (see PeerIdentity). Only
+	requests without token or signature are checked against this.
+	*/
+	Peers  map[string]Perm
+	
+	/*
+	Signed requests must be at most this old (or this far in the future). A
+	signature can not be replayed within this window. If 0, DefaultWindow is used.
+	*/
+	Window time.Duration
+	
+	/*
+	The maximum size of the body of a signed request, that is read to check
+	the signature. If 0, DefaultMaxBody is used.
+	*/
+	MaxBody int64
+	
+	lock   sync.Mutex
+	seen   map[string]time.Time
+	swept  time.Time
+}
+func (a *Authenticator) window() time.Duration {
+	if a.Window<=0 { return DefaultWindow }
+	return a.Window
+}
+func (a *Authenticator) maxBody() int64 {
+	if a.MaxBody<=0 { return DefaultMaxBody }
+	return a.MaxBody
+}
+
+/* Records a nonce. Returns false, if it has been seen within the window. */
+func (a *Authenticator) fresh(nonce string, now time.Time) bool {
+	a.lock.Lock(); defer a.lock.Unlock()
+	if a.seen==nil { a.seen = make(map[string]time.Time) }
+	if _,ok := a.seen[nonce]; ok { return false }
+	a.seen[nonce] = now
+	
+	/* Expired nonces are removed once per window. */
+	w := a.window()
+	if now.Sub(a.swept)>w {
+		for n,t := range a.seen {
+			if now.Sub(t)>2*w { delete(a.seen,n) }
+		}
+		a.swept = now
+	}
+	return true
+}
+
+func (a *Authenticator) checkHMAC(r *http.Request) (Perm,bool) {
+	key,ok := a.Keys[r.Header.Get(hKey)]
+	if !ok { return 0,false }
+	date := r.Header.Get(hDate)
+	nonce := r.Header.Get(hNonce)
+	sig,err := hex.DecodeString(r.Header.Get(hSignature))
+	if err!=nil || nonce=="" { return 0,false }
+	ns,err := strconv.ParseInt(date,10,64)
+	if err!=nil { return 0,false }
+	now := time.Now()
+	if d := now.Sub(time.Unix(0,ns)); d>a.window() || d< -a.window() { return 0,false }
+	
+	var body []byte
+	if r.Body!=nil {
+		body,err = ioutil.ReadAll(http.MaxBytesReader(nil,r.Body,a.maxBody()))
+		r.Body.Close()
+		if err!=nil { return 0,false }
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	if !hmac.Equal(sig,signature(key.Secret,r.Method,r.URL.RequestURI(),date,nonce,body)) { return 0,false }
+	if !a.fresh(r.Header.Get(hKey)+"\x00"+nonce,now) { return 0,false }
+	return key.Perm,true
+}
+
+/* Returns the permissions of the request. If ok is false, no valid credentials were supplied. */
+func (a *Authenticator) Permissions(r *http.Request) (p Perm,ok bool) {
+	if h := r.Header.Get("Authorization"); strings.HasPrefix(h,"Bearer ") {
+		p,ok = a.Tokens[h[7:]]
+		return
+	}
+	if r.Header.Get(hSignature)!="" {
+		return a.checkHMAC(r)
+	}
+	if id := PeerIdentity(r); id!="" {
+		p,ok = a.Peers[id]
+	}
+	return
+}
+
+/*
+Returns the identity of the TLS client, that is the common name (or the first
+DNS name, if there is no common name) of its verified certificate.
+
+Returns "", if the client did not present a verified certificate.
+*/
+func PeerIdentity(r *http.Request) string {
+	if r.TLS==nil || len(r.TLS.VerifiedChains)==0 || len(r.TLS.VerifiedChains[0])==0 { return "" }
+	cert := r.TLS.VerifiedChains[0][0]
+	if cert.Subject.CommonName!="" { return cert.Subject.CommonName }
+	if len(cert.DNSNames)>0 { return cert.DNSNames[0] }
+	return ""
+}
+
+/*
+Checks, whether the request has the permissions 'need'. Returns 0 if so, or
+the HTTP status code to reject the request with otherwise.
+*/
+func (a *Authenticator) Authorize(r *http.Request, need Perm) int {
+	if a==nil { return 0 }
+	p,ok := a.Permissions(r)
+	if !ok { return 401 }
+	if p&need!=need { return 403 }
+	return 0
+}
+
+/* Wraps a handler, so that it is only invoked, if the request has the permissions 'need'. */
+func (a *Authenticator) Guard(need Perm, h httprouter.Handle) httprouter.Handle {
+	if a==nil { return h }
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		if code := a.Authorize(r,need); code!=0 {
+			w.WriteHeader(code)
+			return
+		}
+		h(w,r,ps)
+	}
+}
+
+func signature(secret []byte, method, uri, date, nonce string, body []byte) []byte {
+	bh := sha256.Sum256(body)
+	m := hmac.New(sha256.New,secret)
+	m.Write([]byte(method+"\n"+uri+"\n"+date+"\n"+nonce+"\n"))
+	m.Write([]byte(hex.EncodeToString(bh[:])))
+	return m.Sum(nil)
+}
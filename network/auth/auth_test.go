@@ -0,0 +1,110 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package auth
+
+import "github.com/julienschmidt/httprouter"
+import "net/http"
+import "net/http/httptest"
+import "strings"
+import "testing"
+import "time"
+
+/* Signs a request as a client would and returns it as received by a server. */
+func signed(t *testing.T, h *HMAC, body string) *http.Request {
+	req,err := http.NewRequest("POST","http://host/db/api-r/a",strings.NewReader(body))
+	if err!=nil { t.Fatal(err) }
+	if err = h.Sign(req); err!=nil { t.Fatal(err) }
+	r := httptest.NewRequest("POST","/db/api-r/a",strings.NewReader(body))
+	r.Header = req.Header
+	return r
+}
+
+func TestGuard(t *testing.T) {
+	a := &Authenticator{
+		Tokens: map[string]Perm{"reader":Read},
+		Keys:   map[string]HMACKey{"k":{[]byte("secret"),All}},
+	}
+	h := a.Guard(Write,func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		w.WriteHeader(202)
+	})
+	run := func(r *http.Request) int {
+		rec := httptest.NewRecorder()
+		h(rec,r,nil)
+		return rec.Code
+	}
+	
+	if c := run(httptest.NewRequest("POST","/db/api-r/a",nil)); c!=401 { t.Errorf("anonymous: %d",c) }
+	r := httptest.NewRequest("POST","/db/api-r/a",nil)
+	Token("reader").Sign(r)
+	if c := run(r); c!=403 { t.Errorf("reader: %d",c) }
+	Token("unknown").Sign(r)
+	if c := run(r); c!=401 { t.Errorf("unknown token: %d",c) }
+	
+	key := &HMAC{"k",[]byte("secret")}
+	r = signed(t,key,"body")
+	if c := run(r); c!=202 { t.Errorf("signed: %d",c) }
+	r2 := httptest.NewRequest("POST","/db/api-r/a",strings.NewReader("body"))
+	r2.Header = r.Header
+	if c := run(r2); c!=401 { t.Errorf("replayed: %d",c) }
+	
+	r = signed(t,key,"body")
+	r2 = httptest.NewRequest("POST","/db/api-r/a",strings.NewReader("bodx"))
+	r2.Header = r.Header
+	if c := run(r2); c!=401 { t.Errorf("tampered: %d",c) }
+	
+	if c := run(signed(t,&HMAC{"k",[]byte("wrong")},"body")); c!=401 { t.Errorf("wrong secret: %d",c) }
+	
+	var none *Authenticator
+	if none.Authorize(httptest.NewRequest("GET","/",nil),All)!=0 { t.Error("nil Authenticator rejected a request") }
+}
+
+func TestMaxBody(t *testing.T) {
+	a := &Authenticator{Keys:map[string]HMACKey{"k":{[]byte("secret"),All}},MaxBody:8}
+	key := &HMAC{"k",[]byte("secret")}
+	if _,ok := a.Permissions(signed(t,key,"12345678")); !ok { t.Error("body within the limit rejected") }
+	if _,ok := a.Permissions(signed(t,key,"123456789")); ok { t.Error("body over the limit accepted") }
+}
+
+func TestNonceExpiry(t *testing.T) {
+	a := &Authenticator{Window:time.Minute}
+	now := time.Now()
+	for _,n := range []string{"a","b","c"} {
+		if !a.fresh(n,now) { t.Fatalf("%s: not fresh",n) }
+	}
+	if a.fresh("a",now) { t.Fatal("nonce accepted twice") }
+	
+	/* A single insert after the nonces expired removes them. */
+	if !a.fresh("d",now.Add(3*time.Minute)) { t.Fatal("d: not fresh") }
+	if len(a.seen)!=1 { t.Fatalf("%d nonces kept, want 1",len(a.seen)) }
+}
+
+func TestBaseURL(t *testing.T) {
+	for _,c := range [][3]string{
+		{"","h:1","http://h:1"},
+		{"https","h:1","https://h:1"},
+		{"https","http://h:1/","http://h:1"},
+	} {
+		if got := BaseURL(c[0],c[1]); got!=c[2] { t.Errorf("BaseURL(%q,%q) = %q, want %q",c[0],c[1],got,c[2]) }
+	}
+}
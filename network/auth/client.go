@@ -0,0 +1,96 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package auth
+
+import "net/http"
+
+import "crypto/rand"
+import "encoding/hex"
+import "bytes"
+import "strings"
+import "io/ioutil"
+import "strconv"
+import "time"
+
+/* Credentials are attached to outgoing requests by clients. */
+type Credentials interface{
+	Sign(req *http.Request) error
+}
+
+/* Attaches credentials to the request, if any. */
+func Sign(c Credentials, req *http.Request) error {
+	if c==nil { return nil }
+	return c.Sign(req)
+}
+
+/*
+Returns the base URL of a peer. Addresses are either "host:port", which use
+'scheme' (default "http"), or base URLs, such as "https://host:port".
+*/
+func BaseURL(scheme, addr string) string {
+	if strings.Contains(addr,"://") { return strings.TrimSuffix(addr,"/") }
+	if scheme=="" { scheme = "http" }
+	return scheme+"://"+addr
+}
+
+/* A static bearer token. */
+type Token string
+func (t Token) Sign(req *http.Request) error {
+	req.Header.Set("Authorization","Bearer "+string(t))
+	return nil
+}
+
+/*
+Signs requests with HMAC-SHA256 over the method, the request URI, a timestamp,
+a random nonce and the SHA256 of the body.
+*/
+type HMAC struct{
+	KeyID  string
+	Secret []byte
+}
+func (h *HMAC) Sign(req *http.Request) error {
+	var body []byte
+	var err error
+	if req.GetBody!=nil {
+		rc,err := req.GetBody()
+		if err!=nil { return err }
+		body,err = ioutil.ReadAll(rc)
+		rc.Close()
+		if err!=nil { return err }
+	} else if req.Body!=nil {
+		body,err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err!=nil { return err }
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	var nb [16]byte
+	if _,err = rand.Read(nb[:]); err!=nil { return err }
+	nonce := hex.EncodeToString(nb[:])
+	date := strconv.FormatInt(time.Now().UnixNano(),10)
+	req.Header.Set(hKey,h.KeyID)
+	req.Header.Set(hDate,date)
+	req.Header.Set(hNonce,nonce)
+	req.Header.Set(hSignature,hex.EncodeToString(signature(h.Secret,req.Method,req.URL.RequestURI(),date,nonce,body)))
+	return nil
+}
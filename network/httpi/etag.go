@@ -0,0 +1,101 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package httpi
+
+import "crypto/sha256"
+import "encoding/base64"
+import "container/list"
+import "strings"
+import "sync"
+
+/* Returns the strong ETag of an item, which is derived from its content. */
+func ETag(item []byte) string {
+	sum := sha256.Sum256(item)
+	return `"`+base64.RawURLEncoding.EncodeToString(sum[:16])+`"`
+}
+
+/* Checks an If-None-Match header against an ETag. */
+func etagMatch(header, etag string) bool {
+	for _,t := range strings.Split(header,",") {
+		t = strings.TrimSpace(t)
+		if t=="*" || strings.TrimPrefix(t,"W/")==etag { return true }
+	}
+	return false
+}
+
+/* The default value for ETagCache.MaxEntries. */
+const DefaultETagEntries = 4096
+
+type etagEntry struct{
+	key  string
+	etag string
+	item []byte
+}
+
+/*
+A bounded cache of items and their ETags. If a Client has one, it revalidates
+cached items with If-None-Match, so that unchanged items are not downloaded
+again.
+*/
+type ETagCache struct{
+	/* Maximum number of entries. If 0, DefaultETagEntries is used. */
+	MaxEntries int
+	
+	lock    sync.Mutex
+	lru     list.List
+	entries map[string]*list.Element
+}
+func (e *ETagCache) get(key []byte) (etag string,item []byte) {
+	e.lock.Lock(); defer e.lock.Unlock()
+	el,ok := e.entries[string(key)]
+	if !ok { return }
+	e.lru.MoveToFront(el)
+	ent := el.Value.(*etagEntry)
+	return ent.etag,ent.item
+}
+func (e *ETagCache) put(key []byte, etag string, item []byte) {
+	e.lock.Lock(); defer e.lock.Unlock()
+	if e.entries==nil { e.entries = make(map[string]*list.Element) }
+	if el,ok := e.entries[string(key)]; ok {
+		ent := el.Value.(*etagEntry)
+		ent.etag,ent.item = etag,item
+		e.lru.MoveToFront(el)
+		return
+	}
+	e.entries[string(key)] = e.lru.PushFront(&etagEntry{string(key),etag,item})
+	max := e.MaxEntries
+	if max<=0 { max = DefaultETagEntries }
+	for e.lru.Len()>max {
+		back := e.lru.Back()
+		delete(e.entries,back.Value.(*etagEntry).key)
+		e.lru.Remove(back)
+	}
+}
+func (e *ETagCache) drop(key []byte) {
+	e.lock.Lock(); defer e.lock.Unlock()
+	if el,ok := e.entries[string(key)]; ok {
+		delete(e.entries,string(key))
+		e.lru.Remove(el)
+	}
+}
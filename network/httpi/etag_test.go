@@ -0,0 +1,105 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package httpi
+
+import "github.com/julienschmidt/httprouter"
+import "github.com/byte-mug/brute/datatypes"
+import "net/http"
+import "net/http/httptest"
+import "sync"
+import "testing"
+import "time"
+
+/* Serves h, recording the status codes of the responses. */
+type recorder struct{
+	h     http.Handler
+	lock  sync.Mutex
+	codes []int
+}
+func (r *recorder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	rec := httptest.NewRecorder()
+	r.h.ServeHTTP(rec,req)
+	r.lock.Lock()
+	r.codes = append(r.codes,rec.Code)
+	r.lock.Unlock()
+	for k,v := range rec.Header() { w.Header()[k] = v }
+	w.WriteHeader(rec.Code)
+	w.Write(rec.Body.Bytes())
+}
+func (r *recorder) last() int {
+	r.lock.Lock(); defer r.lock.Unlock()
+	return r.codes[len(r.codes)-1]
+}
+
+func newServer(t *testing.T, s *Server) (*recorder,string) {
+	router := httprouter.New()
+	s.Register(router)
+	rec := &recorder{h:router}
+	srv := httptest.NewServer(rec)
+	t.Cleanup(srv.Close)
+	return rec,srv.URL
+}
+
+func TestETag(t *testing.T) {
+	m := newMem(datatypes.LWW_Factory)
+	rec,url := newServer(t,&Server{DBN:"db",Api:m})
+	c := &Client{Addr:url,DBN:"db",Shared:http.DefaultClient,ETags:&ETagCache{}}
+	get := func() string {
+		v,_ := datatypes.LWW_Decode(c.Obtain([]byte("k")))
+		return string(v)
+	}
+	
+	m.Submit([]byte("k"),datatypes.LWW_Put([]byte("v1")))
+	if v := get(); v!="v1" || rec.last()!=200 { t.Fatalf("got %q, status %d",v,rec.last()) }
+	if v := get(); v!="v1" || rec.last()!=304 { t.Fatalf("revalidation: got %q, status %d",v,rec.last()) }
+	time.Sleep(time.Millisecond)
+	m.Submit([]byte("k"),datatypes.LWW_Put([]byte("v2")))
+	if v := get(); v!="v2" || rec.last()!=200 { t.Fatalf("after a change: got %q, status %d",v,rec.last()) }
+	
+	/* A removed key is dropped from the cache. */
+	m.lock.Lock()
+	delete(m.m,"k")
+	m.lock.Unlock()
+	if _,ok,readable := c.Obtain([]byte("k")); ok || !readable { t.Fatalf("removed key: %v %v",ok,readable) }
+	if etag,_ := c.ETags.get([]byte("k")); etag!="" { t.Fatal("a removed key is still cached") }
+}
+
+func TestETagMatch(t *testing.T) {
+	etag := ETag([]byte("item"))
+	if etag!=ETag([]byte("item")) || etag==ETag([]byte("other")) { t.Fatal("ETags are not derived from the content") }
+	for _,h := range []string{etag,"*",`"x", W/`+etag} {
+		if !etagMatch(h,etag) { t.Errorf("%q does not match %s",h,etag) }
+	}
+	if etagMatch(`"x"`,etag) { t.Error("a foreign ETag matches") }
+}
+
+func TestETagCacheBound(t *testing.T) {
+	e := &ETagCache{MaxEntries:2}
+	e.put([]byte("a"),`"a"`,nil)
+	e.put([]byte("b"),`"b"`,nil)
+	e.get([]byte("a"))
+	e.put([]byte("c"),`"c"`,nil)
+	if etag,_ := e.get([]byte("b")); etag!="" { t.Fatal("the least recently used entry was kept") }
+	if etag,_ := e.get([]byte("a")); etag!=`"a"` { t.Fatal("a recently used entry was evicted") }
+}
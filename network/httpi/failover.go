@@ -0,0 +1,177 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package httpi
+
+import "net/http"
+
+import "github.com/byte-mug/brute/network/auth"
+
+import "errors"
+import "fmt"
+import "math/rand"
+import "sync"
+import "time"
+
+var ErrUnavailable = errors.New("httpi: no endpoint available")
+
+/*
+Controls, how a Client retries requests. As items are idempotent, Submits are
+retried just like Obtains.
+*/
+type RetryPolicy struct{
+	/* Maximum number of attempts per request, across all endpoints. If 0, 3 is used. */
+	Attempts  int
+	
+	/*
+	The backoff before the n-th retry is a random duration (full jitter) below
+	BaseDelay*2^n, but at most MaxDelay. Defaults: 50ms and 2s.
+	*/
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	
+	/*
+	After this many consecutive failures, an endpoint is skipped for Cooldown
+	(the circuit is open), unless no other endpoint is left. Defaults: 5 and 10s.
+	*/
+	Threshold int
+	Cooldown  time.Duration
+}
+func (r *RetryPolicy) attempts(n int) int {
+	if r==nil { return n }
+	if r.Attempts<=0 { return 3 }
+	return r.Attempts
+}
+func (r *RetryPolicy) backoff(n int) time.Duration {
+	if r==nil { return 0 }
+	base,max := r.BaseDelay,r.MaxDelay
+	if base<=0 { base = 50*time.Millisecond }
+	if max<=0 { max = 2*time.Second }
+	d := base
+	for i := 0; i<n && d<max; i++ { d *= 2 }
+	if d>max { d = max }
+	return time.Duration(rand.Int63n(int64(d)+1))
+}
+func (r *RetryPolicy) threshold() (int,time.Duration) {
+	t,c := 5,10*time.Second
+	if r!=nil && r.Threshold>0 { t = r.Threshold }
+	if r!=nil && r.Cooldown>0 { c = r.Cooldown }
+	return t,c
+}
+
+type EndpointStatus struct{
+	Addr        string
+	Failures    int
+	LastError   error
+	LastSuccess time.Time
+	
+	/* If not zero, the circuit is open until then. */
+	OpenUntil   time.Time
+}
+
+type endpoints struct{
+	lock   sync.Mutex
+	status map[string]*EndpointStatus
+}
+func (e *endpoints) get(addr string) *EndpointStatus {
+	if e.status==nil { e.status = make(map[string]*EndpointStatus) }
+	st := e.status[addr]
+	if st==nil {
+		st = &EndpointStatus{Addr:addr}
+		e.status[addr] = st
+	}
+	return st
+}
+
+/* Returns the addresses of the Client, Addr first, then Addrs. */
+func (c *Client) addrs() []string {
+	if len(c.Addrs)==0 { return []string{c.Addr} }
+	if c.Addr=="" { return c.Addrs }
+	r := []string{c.Addr}
+	for _,a := range c.Addrs {
+		if a!=c.Addr { r = append(r,a) }
+	}
+	return r
+}
+
+/* Picks the endpoint for the n-th attempt, skipping those with an open circuit. */
+func (c *Client) pick(addrs []string, n int) string {
+	c.health.lock.Lock(); defer c.health.lock.Unlock()
+	now := time.Now()
+	var closed []string
+	for _,a := range addrs {
+		if now.After(c.health.get(a).OpenUntil) { closed = append(closed,a) }
+	}
+	if len(closed)==0 { closed = addrs }
+	return closed[n%len(closed)]
+}
+func (c *Client) report(addr string, err error) {
+	c.health.lock.Lock(); defer c.health.lock.Unlock()
+	st := c.health.get(addr)
+	if err==nil {
+		st.Failures = 0
+		st.LastSuccess = time.Now()
+		st.OpenUntil = time.Time{}
+		return
+	}
+	st.Failures++
+	st.LastError = err
+	if t,cool := c.Retry.threshold(); st.Failures>=t {
+		st.OpenUntil = time.Now().Add(cool)
+	}
+}
+
+/* Returns the health of all endpoints, the Client has talked to. */
+func (c *Client) Health() []EndpointStatus {
+	c.health.lock.Lock(); defer c.health.lock.Unlock()
+	r := make([]EndpointStatus,0,len(c.health.status))
+	for _,a := range c.addrs() {
+		if st,ok := c.health.status[a]; ok { r = append(r,*st) }
+	}
+	return r
+}
+
+/*
+Performs a request, retrying it on other endpoints (after a backoff) on
+connection errors and server errors (5xx). 'mk' creates the request for the
+base URL of an endpoint.
+*/
+func (c *Client) try(mk func(base string) (*http.Request,error)) (*http.Response,error) {
+	addrs := c.addrs()
+	err := ErrUnavailable
+	for n := 0; n<c.Retry.attempts(len(addrs)); n++ {
+		if n>0 { time.Sleep(c.Retry.backoff(n-1)) }
+		addr := c.pick(addrs,n)
+		req,e := mk(auth.BaseURL(c.Scheme,addr)+"/"+c.DBN)
+		if e!=nil { return nil,e }
+		resp,e := c.do(req)
+		if e==nil && resp.StatusCode>=500 {
+			resp.Body.Close()
+			e = fmt.Errorf("httpi: status %d",resp.StatusCode)
+		}
+		c.report(addr,e)
+		if e==nil { return resp,nil }
+		err = e
+	}
+	return nil,err
+}
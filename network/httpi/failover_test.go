@@ -0,0 +1,86 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package httpi
+
+import "github.com/byte-mug/brute/datatypes"
+import "net/http"
+import "net/http/httptest"
+import "sync/atomic"
+import "testing"
+import "time"
+
+func TestFailover(t *testing.T) {
+	m := newMem(datatypes.LWW_Factory)
+	_,good := newServer(t,&Server{DBN:"db",Api:m})
+	var failed int64
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&failed,1)
+		w.WriteHeader(503)
+	}))
+	defer bad.Close()
+	
+	c := &Client{Addr:"127.0.0.1:1",Addrs:[]string{bad.URL,good},DBN:"db",Shared:http.DefaultClient,
+		Retry:&RetryPolicy{Attempts:4,BaseDelay:time.Millisecond,Threshold:1,Cooldown:time.Minute}}
+	if !c.Submit([]byte("k"),datatypes.LWW_Put([]byte("v"))) { t.Fatal("Submit did not fail over") }
+	if v,ok := datatypes.LWW_Decode(c.Obtain([]byte("k"))); !ok || string(v)!="v" { t.Fatalf("got %q %v",v,ok) }
+	n := 0
+	c.Stream(func(key, item []byte){ n++ })
+	if n!=1 { t.Fatalf("streamed %d keys, want 1",n) }
+	
+	/* The failed endpoints are skipped while their circuits are open. */
+	if f := atomic.LoadInt64(&failed); f!=1 { t.Fatalf("the failing endpoint got %d requests, want 1",f) }
+	h := c.Health()
+	if len(h)!=3 || h[0].OpenUntil.IsZero() || h[1].OpenUntil.IsZero() || !h[2].OpenUntil.IsZero() || h[2].LastSuccess.IsZero() {
+		t.Fatalf("health %+v",h)
+	}
+}
+
+func TestUnavailable(t *testing.T) {
+	var calls int64
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&calls,1)
+		w.WriteHeader(500)
+	}))
+	defer bad.Close()
+	c := &Client{Addr:bad.URL,DBN:"db",Shared:http.DefaultClient,Retry:&RetryPolicy{Attempts:3,BaseDelay:time.Millisecond}}
+	if c.Submit([]byte("k"),nil) { t.Fatal("Submit to a failing endpoint succeeded") }
+	if n := atomic.LoadInt64(&calls); n!=3 { t.Fatalf("%d attempts, want 3",n) }
+	
+	/* Without a RetryPolicy, every endpoint is tried once. */
+	c = &Client{Addr:bad.URL,DBN:"db",Shared:http.DefaultClient}
+	if _,_,readable := c.Obtain([]byte("k")); readable { t.Fatal("Obtain from a failing endpoint succeeded") }
+	if n := atomic.LoadInt64(&calls); n!=4 { t.Fatalf("%d attempts, want 4",n) }
+}
+
+func TestBackoff(t *testing.T) {
+	r := &RetryPolicy{BaseDelay:10*time.Millisecond,MaxDelay:50*time.Millisecond}
+	for n := 0; n<10; n++ {
+		limit := 10*time.Millisecond<<uint(n)
+		if limit>50*time.Millisecond { limit = 50*time.Millisecond }
+		for i := 0; i<20; i++ {
+			if d := r.backoff(n); d<0 || d>limit { t.Fatalf("backoff(%d) = %v exceeds %v",n,d,limit) }
+		}
+	}
+	if (*RetryPolicy)(nil).backoff(3)!=0 { t.Fatal("a nil policy backs off") }
+}
@@ -27,6 +27,8 @@ import "github.com/julienschmidt/httprouter"
 import "net/http"
 
 import "github.com/byte-mug/brute/api"
+import "github.com/byte-mug/brute/layers/watch"
+import "github.com/byte-mug/brute/network/auth"
 import "encoding/base64"
 import "github.com/vmihailenco/msgpack"
 import "bufio"
@@ -36,6 +38,12 @@ import "io/ioutil"
 type Server struct {
 	DBN string
 	Api api.StorageFacade
+	
+	/* Optional: if set, the watch endpoint is registered. */
+	Watch *watch.Hub
+	
+	/* Optional: if set, requests must be authenticated. */
+	Auth *auth.Authenticator
 }
 func (s *Server) obtgain(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	key,err := base64.RawURLEncoding.DecodeString(ps.ByName("key"))
@@ -49,6 +57,12 @@ func (s *Server) obtgain(w http.ResponseWriter, r *http.Request, ps httprouter.P
 	} else if !ok {
 		w.WriteHeader(404)
 	} else {
+		etag := ETag(item)
+		w.Header().Set("ETag",etag)
+		if inm := r.Header.Get("If-None-Match"); inm!="" && etagMatch(inm,etag) {
+			w.WriteHeader(304)
+			return
+		}
 		w.Header().Add("Content-Type","application/octet-stream")
 		w.WriteHeader(200)
 		w.Write(item)
@@ -72,6 +86,23 @@ func (s *Server) submit(w http.ResponseWriter, r *http.Request, ps httprouter.Pa
 		w.WriteHeader(202)
 	}
 }
+func (s *Server) remove(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	key,err := base64.RawURLEncoding.DecodeString(ps.ByName("key"))
+	if err!=nil {
+		w.WriteHeader(400)
+		return
+	}
+	d,ok := s.Api.(api.Deleter)
+	if !ok {
+		w.WriteHeader(405)
+		return
+	}
+	if !d.Delete(key) {
+		w.WriteHeader(500)
+	} else {
+		w.WriteHeader(204)
+	}
+}
 func (s *Server) stream(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	w.Header().Add("Content-Type","application/x-msgpack")
 	llw := bufio.NewWriter(w)
@@ -84,47 +115,119 @@ func (s *Server) stream(w http.ResponseWriter, r *http.Request, ps httprouter.Pa
 }
 func (s *Server) Register(r *httprouter.Router) {
 	u1 := "/"+s.DBN+"/api-r/:key"
-	r.Handle("GET",u1,s.obtgain)
-	r.Handle("PUT",u1,s.submit)
-	r.Handle("POST",u1,s.submit)
-	r.GET("/"+s.DBN+"/api-stream",s.stream)
+	r.Handle("GET",u1,s.Auth.Guard(auth.Read,s.obtgain))
+	r.Handle("PUT",u1,s.Auth.Guard(auth.Write,s.submit))
+	r.Handle("POST",u1,s.Auth.Guard(auth.Write,s.submit))
+	/* Physical removal bypasses the tombstones and is not replicated: not for ordinary writers. */
+	r.Handle("DELETE",u1,s.Auth.Guard(auth.Replicate,s.remove))
+	r.GET("/"+s.DBN+"/api-stream",s.Auth.Guard(auth.Read,s.stream))
+	if s.Watch!=nil {
+		r.GET("/"+s.DBN+"/api-watch",s.Auth.Guard(auth.Read,s.watch))
+	}
 }
 
 type Client struct{
+	/* Either "host:port" or a base URL, such as "https://host:port". */
 	Addr string
 	DBN string
 	Shared *http.Client
+	
+	/* The URL scheme used with a "host:port" Addr. If "", "http" is used. */
+	Scheme string
+	
+	/* Optional: the credentials to authenticate with. */
+	Auth auth.Credentials
+	
+	/* Optional: if set, obtained items are cached and revalidated using their ETags. */
+	ETags *ETagCache
+	
+	/*
+	Optional: further replicas to fail over to. Requests go to Addr first (if
+	set), then to the replicas in Addrs.
+	*/
+	Addrs []string
+	
+	/* Optional: if nil, every endpoint is tried once, without backoff. */
+	Retry *RetryPolicy
+	
+	health endpoints
 }
-func (c *Client) url(key []byte) string {
-	return "http://"+c.Addr+"/"+c.DBN+"/api-r/"+base64.RawURLEncoding.EncodeToString(key)
+/*
+Returns the base URL of the first endpoint. Used by Watch, which does not fail
+over, as event sequence numbers are local to a server.
+*/
+func (c *Client) base() string {
+	return auth.BaseURL(c.Scheme,c.addrs()[0])+"/"+c.DBN
+}
+func keyPath(key []byte) string {
+	return "/api-r/"+base64.RawURLEncoding.EncodeToString(key)
+}
+func (c *Client) do(req *http.Request) (*http.Response,error) {
+	if err := auth.Sign(c.Auth,req); err!=nil { return nil,err }
+	return c.Shared.Do(req)
 }
 func (c *Client) Submit(key, item []byte) (ok bool) {
-	resp,err := c.Shared.Post(c.url(key),"application/octet-stream",bytes.NewReader(item))
+	resp,err := c.try(func(base string) (*http.Request,error) {
+		req,err := http.NewRequest("POST",base+keyPath(key),bytes.NewReader(item))
+		if err!=nil { return nil,err }
+		req.Header.Set("Content-Type","application/octet-stream")
+		return req,nil
+	})
 	if err!=nil { return }
 	resp.Body.Close()
 	ok = resp.StatusCode==202
 	return
 }
 func (c *Client) Obtain(key []byte) (item []byte,ok,readable bool) {
-	resp,err := c.Shared.Get(c.url(key))
+	var etag string
+	var cached []byte
+	if c.ETags!=nil { etag,cached = c.ETags.get(key) }
+	resp,err := c.try(func(base string) (*http.Request,error) {
+		req,err := http.NewRequest("GET",base+keyPath(key),nil)
+		if err!=nil { return nil,err }
+		if etag!="" { req.Header.Set("If-None-Match",etag) }
+		return req,nil
+	})
 	if err!=nil { return }
 	defer resp.Body.Close()
 	switch resp.StatusCode {
+	case 304:
+		if etag=="" { break }
+		return cached,true,true
 	case 200:
-		item,_ = ioutil.ReadAll(resp.Body)
+		item,err = ioutil.ReadAll(resp.Body)
+		if err!=nil { return nil,false,false }
 		ok = true
-		fallthrough
+		readable = true
+		if c.ETags!=nil {
+			if et := resp.Header.Get("ETag"); et!="" { c.ETags.put(key,et,item) }
+		}
 	case 404:
 		readable = true
+		if c.ETags!=nil { c.ETags.drop(key) }
 	}
 	return
 }
-func (c *Client) Stream(f func(key, item []byte)) {
-	req,err := http.NewRequest("GET","http://"+c.Addr+"/"+c.DBN+"/api-stream/",nil)
+/*
+Removes a key-item-pair from the server, if it supports that. Requires the
+Replicate permission, as the removal is neither logged nor replicated.
+*/
+func (c *Client) Delete(key []byte) (ok bool) {
+	resp,err := c.try(func(base string) (*http.Request,error) {
+		return http.NewRequest("DELETE",base+keyPath(key),nil)
+	})
 	if err!=nil { return }
-	resp,err := c.Shared.Do(req)
+	resp.Body.Close()
+	ok = resp.StatusCode==204
+	return
+}
+func (c *Client) Stream(f func(key, item []byte)) {
+	resp,err := c.try(func(base string) (*http.Request,error) {
+		return http.NewRequest("GET",base+"/api-stream",nil)
+	})
 	if err!=nil { return }
 	defer resp.Body.Close()
+	if resp.StatusCode!=200 { return }
 	dec := msgpack.NewDecoder(bufio.NewReader(resp.Body))
 	var key,item []byte
 	i := []interface{}{&key,&item}
@@ -136,3 +239,4 @@ func (c *Client) Stream(f func(key, item []byte)) {
 }
 
 var _ api.StorageFacade = (*Client)(nil)
+var _ api.Deleter = (*Client)(nil)
@@ -0,0 +1,194 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package httpi
+
+import "github.com/julienschmidt/httprouter"
+import "net/http"
+
+import "github.com/byte-mug/brute/api"
+import "github.com/byte-mug/brute/datatypes"
+import "github.com/byte-mug/brute/network/auth"
+import "encoding/json"
+import "bytes"
+import "io/ioutil"
+import "math"
+
+type Datatype uint
+const (
+	DT_LWW Datatype = iota
+	DT_Table
+)
+
+/*
+A datatype-aware REST API, that builds the items server-side, so that clients
+don't need to speak msgpack. Keys are plain path segments:
+
+	GET    /{DBN}/api-json/{key}  returns the value (LWW) or the row as JSON object (Table)
+	PUT    /{DBN}/api-json/{key}  sets the value (LWW) or replaces the row (Table)
+	PATCH  /{DBN}/api-json/{key}  updates the supplied fields of the row (Table only)
+	DELETE /{DBN}/api-json/{key}  deletes the key by writing a tombstone
+
+LWW values are raw request/response bodies.
+*/
+type RestServer struct {
+	DBN  string
+	Api  api.StorageFacade
+	Type Datatype
+	
+	/* Optional: if set, requests must be authenticated. */
+	Auth *auth.Authenticator
+}
+func restKey(ps httprouter.Params) []byte {
+	key := ps.ByName("key")
+	if len(key)>0 && key[0]=='/' { key = key[1:] }
+	return []byte(key)
+}
+
+/*
+JSON numbers are decoded as float64. Integral ones are converted to int64, so
+that they are stored as integers.
+*/
+func normalize(v interface{}) interface{} {
+	switch t := v.(type) {
+	case float64:
+		if t==math.Trunc(t) && math.Abs(t)<(1<<53) { return int64(t) }
+	case map[string]interface{}:
+		for k,e := range t { t[k] = normalize(e) }
+	case []interface{}:
+		for i,e := range t { t[i] = normalize(e) }
+	}
+	return v
+}
+func readRow(r *http.Request) (datatypes.Row,bool) {
+	var row map[string]interface{}
+	if json.NewDecoder(r.Body).Decode(&row)!=nil || row==nil { return nil,false }
+	normalize(row)
+	return datatypes.Row(row),true
+}
+
+func (s *RestServer) get(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	key := restKey(ps)
+	if len(key)==0 {
+		w.WriteHeader(400)
+		return
+	}
+	item,ok,readable := s.Api.Obtain(key)
+	if !readable {
+		w.WriteHeader(500)
+		return
+	}
+	switch s.Type {
+	case DT_LWW:
+		value,ok := datatypes.LWW_Decode(item,ok,readable)
+		if !ok {
+			w.WriteHeader(404)
+			return
+		}
+		w.Header().Set("Content-Type","application/octet-stream")
+		w.WriteHeader(200)
+		w.Write(value)
+	case DT_Table:
+		row,ok := datatypes.Table_Decode(item,ok,readable)
+		if !ok {
+			w.WriteHeader(404)
+			return
+		}
+		buf := new(bytes.Buffer)
+		if json.NewEncoder(buf).Encode(map[string]interface{}(row))!=nil {
+			w.WriteHeader(500)
+			return
+		}
+		w.Header().Set("Content-Type","application/json")
+		w.WriteHeader(200)
+		w.Write(buf.Bytes())
+	}
+}
+func (s *RestServer) write(w http.ResponseWriter, key, item []byte) {
+	if !s.Api.Submit(key,item) {
+		w.WriteHeader(500)
+	} else {
+		w.WriteHeader(204)
+	}
+}
+func (s *RestServer) put(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	key := restKey(ps)
+	if len(key)==0 {
+		w.WriteHeader(400)
+		return
+	}
+	switch s.Type {
+	case DT_LWW:
+		value,err := ioutil.ReadAll(r.Body)
+		if err!=nil {
+			w.WriteHeader(400)
+			return
+		}
+		s.write(w,key,datatypes.LWW_Put(value))
+	case DT_Table:
+		row,ok := readRow(r)
+		if !ok {
+			w.WriteHeader(400)
+			return
+		}
+		s.write(w,key,datatypes.Table_Replace(row))
+	}
+}
+func (s *RestServer) patch(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	key := restKey(ps)
+	if len(key)==0 {
+		w.WriteHeader(400)
+		return
+	}
+	if s.Type!=DT_Table {
+		w.WriteHeader(405)
+		return
+	}
+	row,ok := readRow(r)
+	if !ok {
+		w.WriteHeader(400)
+		return
+	}
+	s.write(w,key,datatypes.Table_Put(row))
+}
+func (s *RestServer) delete(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	key := restKey(ps)
+	if len(key)==0 {
+		w.WriteHeader(400)
+		return
+	}
+	switch s.Type {
+	case DT_LWW:
+		s.write(w,key,datatypes.LWW_Delete())
+	case DT_Table:
+		s.write(w,key,datatypes.Table_Delete())
+	}
+}
+func (s *RestServer) Register(r *httprouter.Router) {
+	u1 := "/"+s.DBN+"/api-json/*key"
+	r.Handle("GET",u1,s.Auth.Guard(auth.Read,s.get))
+	r.Handle("PUT",u1,s.Auth.Guard(auth.Write,s.put))
+	r.Handle("POST",u1,s.Auth.Guard(auth.Write,s.put))
+	r.Handle("PATCH",u1,s.Auth.Guard(auth.Write,s.patch))
+	r.Handle("DELETE",u1,s.Auth.Guard(auth.Write,s.delete))
+}
@@ -0,0 +1,286 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package httpi
+
+import "github.com/julienschmidt/httprouter"
+import "net/http"
+
+import "github.com/byte-mug/brute/layers/watch"
+import "encoding/base64"
+import "encoding/json"
+import "context"
+import "bufio"
+import "fmt"
+import "strconv"
+import "strings"
+import "time"
+
+/*
+The watch endpoint
+
+	GET /{DBN}/api-watch?key={base64url}
+	GET /{DBN}/api-watch?prefix={base64url}
+
+streams change events as Server-Sent Events, if the client accepts
+"text/event-stream". Every event carries its sequence number as id, so that
+clients resume by sending the "Last-Event-ID" header. The stream starts with a
+"ready" event, carrying the sequence number, the stream starts after. If the
+events since then are no longer known, a "gap" event follows.
+
+Other clients get a long-poll response: it waits up to 'timeout' (default:
+30s) for events after the sequence number 'since' and returns them as JSON
+object {"events":[...],"next":seq,"gap":bool}.
+*/
+
+const (
+	watchBuffer  = 256
+	watchPing    = 15*time.Second
+	watchTimeout = 30*time.Second
+	watchRetry   = time.Second
+)
+
+type jsonEvent struct{
+	Seq  uint64 `json:"seq"`
+	Key  []byte `json:"key"`
+	Item []byte `json:"item"`
+	Lost uint64 `json:"lost,omitempty"`
+}
+
+type jsonPoll struct{
+	Events []jsonEvent `json:"events"`
+	Next   uint64      `json:"next"`
+	Gap    bool        `json:"gap"`
+}
+
+func (s *Server) watch(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	q := r.URL.Query()
+	prefix := true
+	arg := q.Get("prefix")
+	if q.Get("key")!="" {
+		prefix = false
+		arg = q.Get("key")
+	}
+	key,err := base64.RawURLEncoding.DecodeString(arg)
+	if err!=nil {
+		w.WriteHeader(400)
+		return
+	}
+	since := s.Watch.Seq()
+	if v := r.Header.Get("Last-Event-ID"); v!="" {
+		since,err = strconv.ParseUint(v,10,64)
+	} else if v = q.Get("since"); v!="" {
+		since,err = strconv.ParseUint(v,10,64)
+	}
+	if err!=nil {
+		w.WriteHeader(400)
+		return
+	}
+	
+	if strings.Contains(r.Header.Get("Accept"),"text/event-stream") {
+		s.watchSSE(w,r,key,prefix,since)
+	} else {
+		s.watchPoll(w,r,key,prefix,since)
+	}
+}
+func (s *Server) watchSSE(w http.ResponseWriter, r *http.Request, key []byte, prefix bool, since uint64) {
+	fl,ok := w.(http.Flusher)
+	if !ok {
+		w.WriteHeader(500)
+		return
+	}
+	sub,backlog,complete := s.Watch.Resume(key,prefix,since,watchBuffer)
+	defer sub.Close()
+	
+	w.Header().Set("Content-Type","text/event-stream")
+	w.Header().Set("Cache-Control","no-cache")
+	w.WriteHeader(200)
+	bw := bufio.NewWriter(w)
+	send := func(ev watch.Event) error {
+		data,_ := json.Marshal(jsonEvent{ev.Seq,ev.Key,ev.Item,ev.Lost})
+		_,err := fmt.Fprintf(bw,"id: %d\nevent: change\ndata: %s\n\n",ev.Seq,data)
+		return err
+	}
+	last := since
+	if complete {
+		fmt.Fprintf(bw,"id: %d\nevent: ready\ndata: {}\n\n",since)
+	} else {
+		last = 0
+		fmt.Fprintf(bw,"event: ready\ndata: {}\n\nevent: gap\ndata: {}\n\n")
+	}
+	for _,ev := range backlog {
+		send(ev)
+		last = ev.Seq
+	}
+	bw.Flush(); fl.Flush()
+	
+	ping := time.NewTicker(watchPing)
+	defer ping.Stop()
+	for {
+		select {
+		case ev,ok := <-sub.C:
+			if !ok { return }
+			if ev.Seq<=last { continue }
+			if send(ev)!=nil { return }
+		case <-ping.C:
+			if _,err := bw.WriteString(": ping\n\n"); err!=nil { return }
+		case <-r.Context().Done():
+			return
+		}
+		if bw.Flush()!=nil { return }
+		fl.Flush()
+	}
+}
+func (s *Server) watchPoll(w http.ResponseWriter, r *http.Request, key []byte, prefix bool, since uint64) {
+	timeout := watchTimeout
+	if v := r.URL.Query().Get("timeout"); v!="" {
+		d,err := time.ParseDuration(v)
+		if err!=nil {
+			w.WriteHeader(400)
+			return
+		}
+		if d<timeout { timeout = d }
+	}
+	sub,backlog,complete := s.Watch.Resume(key,prefix,since,watchBuffer)
+	defer sub.Close()
+	
+	/* After a gap, all events are new; .Next starts after the subscription. */
+	res := jsonPoll{Events:[]jsonEvent{},Next:since,Gap:!complete}
+	if res.Gap { res.Next = 0 }
+	add := func(ev watch.Event) {
+		if ev.Seq<=res.Next { return }
+		res.Events = append(res.Events,jsonEvent{ev.Seq,ev.Key,ev.Item,ev.Lost})
+		res.Next = ev.Seq
+	}
+	for _,ev := range backlog { add(ev) }
+	if len(res.Events)==0 && complete {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		select {
+		case ev := <-sub.C: add(ev)
+		case <-t.C:
+		case <-r.Context().Done(): return
+		}
+	}
+	for drained := false; !drained; {
+		select {
+		case ev := <-sub.C: add(ev)
+		default: drained = true
+		}
+	}
+	if res.Gap && res.Next<sub.Seq { res.Next = sub.Seq }
+	w.Header().Set("Content-Type","application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(res)
+}
+
+/*
+Subscribes to the changes of a key (or of all keys starting with it, if prefix
+is true) using the watch endpoint.
+
+The subscription reconnects on errors and resumes from the last event seen. If
+events have been lost meanwhile, the next event has Lost set to
+watch.LostUnknown. The channel is closed, once 'stop' is closed.
+*/
+func (c *Client) Watch(key []byte, prefix bool, stop <-chan struct{}) <-chan watch.Event {
+	ch := make(chan watch.Event,watchBuffer)
+	ctx,cancel := context.WithCancel(context.Background())
+	go func() {
+		<-stop
+		cancel()
+	}()
+	go func() {
+		defer close(ch)
+		var last uint64
+		var lost, started bool
+		handle := func(event string, id uint64, hasID bool, data string) bool {
+			switch event {
+			case "ready":
+				if hasID { last = id }
+				started = true
+			case "gap":
+				lost = true
+			case "change":
+				var je jsonEvent
+				if json.Unmarshal([]byte(data),&je)!=nil { return true }
+				ev := watch.Event{Seq:je.Seq,Key:je.Key,Item:je.Item,Lost:je.Lost}
+				if lost { ev.Lost = watch.LostUnknown }
+				select {
+				case ch <- ev:
+				case <-stop: return false
+				}
+				lost = false
+				last = ev.Seq
+			}
+			return true
+		}
+		for {
+			err := c.watchOnce(ctx,key,prefix,started,last,handle)
+			if err==context.Canceled { return }
+			select {
+			case <-stop: return
+			case <-time.After(watchRetry):
+			}
+		}
+	}()
+	return ch
+}
+func (c *Client) watchOnce(ctx context.Context, key []byte, prefix, resume bool, last uint64, f func(event string, id uint64, hasID bool, data string) bool) error {
+	arg := "key="
+	if prefix { arg = "prefix=" }
+	req,err := http.NewRequest("GET",c.base()+"/api-watch?"+arg+base64.RawURLEncoding.EncodeToString(key),nil)
+	if err!=nil { return err }
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept","text/event-stream")
+	if resume { req.Header.Set("Last-Event-ID",strconv.FormatUint(last,10)) }
+	resp,err := c.do(req)
+	if err!=nil {
+		if ctx.Err()!=nil { return ctx.Err() }
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode!=200 { return fmt.Errorf("httpi: watch: status %d",resp.StatusCode) }
+	
+	sc := bufio.NewScanner(resp.Body)
+	sc.Buffer(nil,1<<26)
+	var event, data string
+	var id uint64
+	var hasID bool
+	for sc.Scan() {
+		line := sc.Text()
+		switch {
+		case line=="":
+			if event!="" && !f(event,id,hasID,data) { return context.Canceled }
+			event,data,hasID = "","",false
+		case strings.HasPrefix(line,"id:"):
+			id,err = strconv.ParseUint(strings.TrimSpace(line[3:]),10,64)
+			hasID = err==nil
+		case strings.HasPrefix(line,"event:"):
+			event = strings.TrimSpace(line[6:])
+		case strings.HasPrefix(line,"data:"):
+			data += strings.TrimSpace(line[5:])
+		}
+	}
+	if ctx.Err()!=nil { return ctx.Err() }
+	return sc.Err()
+}
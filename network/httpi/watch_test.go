@@ -0,0 +1,110 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package httpi
+
+import "github.com/julienschmidt/httprouter"
+import "github.com/byte-mug/brute/layers/watch"
+import "github.com/byte-mug/brute/datatypes"
+import "github.com/byte-mug/brute/utils"
+import "github.com/byte-mug/brute/api"
+import "encoding/base64"
+import "encoding/json"
+import "net/http"
+import "net/http/httptest"
+import "sort"
+import "sync"
+import "testing"
+
+/* An in-memory StorageFacade. */
+type mem struct{
+	utils.MergeUtil
+	lock sync.Mutex
+	m    map[string][]byte
+}
+func newMem(f api.MergerFactory) *mem {
+	m := &mem{m:make(map[string][]byte)}
+	m.Merger = f
+	return m
+}
+func (m *mem) Submit(key, item []byte) bool {
+	m.lock.Lock(); defer m.lock.Unlock()
+	if o,ok := m.m[string(key)]; ok { item,_ = m.Merge(o,item) }
+	m.m[string(key)] = item
+	return true
+}
+func (m *mem) Obtain(key []byte) ([]byte,bool,bool) {
+	m.lock.Lock(); defer m.lock.Unlock()
+	item,ok := m.m[string(key)]
+	return item,ok,true
+}
+func (m *mem) Stream(f func(key, item []byte)) {
+	m.lock.Lock()
+	keys := make([]string,0,len(m.m))
+	for k := range m.m { keys = append(keys,k) }
+	m.lock.Unlock()
+	sort.Strings(keys)
+	for _,k := range keys {
+		item,_,_ := m.Obtain([]byte(k))
+		f([]byte(k),item)
+	}
+}
+
+func poll(t *testing.T, h http.Handler, since string) (res jsonPoll) {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec,httptest.NewRequest("GET","/?prefix="+base64.RawURLEncoding.EncodeToString([]byte("a"))+"&since="+since+"&timeout=10ms",nil))
+	if rec.Code!=200 { t.Fatalf("status %d",rec.Code) }
+	if err := json.Unmarshal(rec.Body.Bytes(),&res); err!=nil { t.Fatal(err) }
+	return
+}
+
+func TestWatchPoll(t *testing.T) {
+	hub := &watch.Hub{History:2}
+	w := &watch.Watch{Api:newMem(datatypes.LWW_Factory),Hub:hub}
+	w.Merger = datatypes.LWW_Factory
+	s := &Server{DBN:"db",Api:w,Watch:hub}
+	h := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		s.watch(rw,r,httprouter.Params{})
+	})
+	for _,k := range []string{"a1","b1","a2","a3"} {
+		w.Submit([]byte(k),datatypes.LWW_Put([]byte("x")))
+	}
+	
+	res := poll(t,h,"2")
+	if res.Gap || len(res.Events)!=2 || res.Next!=4 { t.Fatalf("got %+v",res) }
+	
+	/* Event 1 is no longer in the history. */
+	res = poll(t,h,"0")
+	if !res.Gap || len(res.Events)!=2 || res.Next!=4 { t.Fatalf("got %+v",res) }
+	
+	/* Nothing new. */
+	res = poll(t,h,"4")
+	if res.Gap || len(res.Events)!=0 || res.Next!=4 { t.Fatalf("got %+v",res) }
+	
+	/* A sequence number from before a restart. */
+	res = poll(t,h,"100")
+	if !res.Gap || len(res.Events)!=0 || res.Next!=4 { t.Fatalf("got %+v",res) }
+	w.Submit([]byte("a4"),datatypes.LWW_Put([]byte("x")))
+	res = poll(t,h,"4")
+	if len(res.Events)!=1 || res.Events[0].Seq!=5 { t.Fatalf("got %+v",res) }
+}
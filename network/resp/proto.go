@@ -0,0 +1,144 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package resp
+
+import "bufio"
+import "bytes"
+import "errors"
+import "io"
+import "strconv"
+import "strings"
+
+var errProtocol = errors.New("resp: protocol error")
+
+/* The default maximum size of a bulk string. */
+const DefaultMaxBulk = 64<<20
+
+/* The maximum number of arguments of a command. */
+const maxArgs = 1<<20
+
+/* The maximum length of a line, such as an inline command. */
+const maxLine = 64<<10
+
+func readLine(r *bufio.Reader) (string,error) {
+	var line []byte
+	for {
+		frag,err := r.ReadSlice('\n')
+		if len(line)+len(frag)>maxLine { return "",errProtocol }
+		line = append(line,frag...)
+		if err==bufio.ErrBufferFull { continue }
+		if err!=nil { return "",err }
+		return strings.TrimRight(string(line),"\r\n"),nil
+	}
+}
+
+/*
+Reads a command, either as array of bulk strings or as inline command. Bulk
+strings longer than maxBulk are rejected; they are read incrementally, so the
+memory used grows with the data actually received.
+
+A null array (*-1) yields an empty command.
+*/
+func readCommand(r *bufio.Reader, maxBulk int) ([][]byte,error) {
+	line,err := readLine(r)
+	if err!=nil { return nil,err }
+	if len(line)==0 || line[0]!='*' {
+		var args [][]byte
+		for _,f := range strings.Fields(line) { args = append(args,[]byte(f)) }
+		return args,nil
+	}
+	n,err := strconv.Atoi(line[1:])
+	if err!=nil || -1>n || n>maxArgs { return nil,errProtocol }
+	var args [][]byte
+	for i := 0; i<n; i++ {
+		line,err = readLine(r)
+		if err!=nil { return nil,err }
+		if len(line)==0 || line[0]!='$' { return nil,errProtocol }
+		l,err := strconv.Atoi(line[1:])
+		if err!=nil || l<0 || l>maxBulk { return nil,errProtocol }
+		var buf bytes.Buffer
+		m,err := buf.ReadFrom(io.LimitReader(r,int64(l)+2))
+		if err!=nil { return nil,err }
+		if m<int64(l)+2 { return nil,io.ErrUnexpectedEOF }
+		args = append(args,buf.Bytes()[:l])
+	}
+	return args,nil
+}
+
+type writer struct{
+	*bufio.Writer
+}
+func (w writer) simple(s string) { w.WriteString("+"+s+"\r\n") }
+func (w writer) error(s string)  { w.WriteString("-"+s+"\r\n") }
+func (w writer) integer(i int64) { w.WriteString(":"+strconv.FormatInt(i,10)+"\r\n") }
+func (w writer) null()           { w.WriteString("$-1\r\n") }
+func (w writer) array(n int)     { w.WriteString("*"+strconv.Itoa(n)+"\r\n") }
+func (w writer) bulk(b []byte) {
+	w.WriteString("$"+strconv.Itoa(len(b))+"\r\n")
+	w.Write(b)
+	w.WriteString("\r\n")
+}
+
+/* Redis-style glob matching: '*', '?', '[...]' and '\\' escapes. '*' also matches '/'. */
+func match(pattern, s string) bool {
+	for len(pattern)>0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern)>0 && pattern[0]=='*' { pattern = pattern[1:] }
+			if len(pattern)==0 { return true }
+			for i := 0; i<=len(s); i++ {
+				if match(pattern,s[i:]) { return true }
+			}
+			return false
+		case '?':
+			if len(s)==0 { return false }
+		case '[':
+			if len(s)==0 { return false }
+			end := strings.IndexByte(pattern[1:],']')
+			if end<0 { return false }
+			set := pattern[1:end+1]
+			neg := len(set)>0 && set[0]=='^'
+			if neg { set = set[1:] }
+			found := false
+			for i := 0; i<len(set); i++ {
+				if i+2<len(set) && set[i+1]=='-' {
+					if set[i]<=s[0] && s[0]<=set[i+2] { found = true }
+					i += 2
+				} else if set[i]==s[0] {
+					found = true
+				}
+			}
+			if found==neg { return false }
+			pattern = pattern[end+1:]
+		case '\\':
+			if len(pattern)>1 { pattern = pattern[1:] }
+			fallthrough
+		default:
+			if len(s)==0 || s[0]!=pattern[0] { return false }
+		}
+		pattern = pattern[1:]
+		s = s[1:]
+	}
+	return len(s)==0
+}
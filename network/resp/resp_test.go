@@ -0,0 +1,197 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package resp
+
+import "github.com/byte-mug/brute/datatypes"
+import "github.com/byte-mug/brute/utils"
+import "github.com/byte-mug/brute/api"
+import "bufio"
+import "fmt"
+import "io"
+import "net"
+import "reflect"
+import "sort"
+import "strconv"
+import "strings"
+import "sync"
+import "testing"
+import "time"
+
+/* An in-memory StorageFacade. */
+type mem struct{
+	utils.MergeUtil
+	lock sync.Mutex
+	m    map[string][]byte
+}
+func newMem(f api.MergerFactory) *mem {
+	m := &mem{m:make(map[string][]byte)}
+	m.Merger = f
+	return m
+}
+func (m *mem) Submit(key, item []byte) bool {
+	m.lock.Lock(); defer m.lock.Unlock()
+	if o,ok := m.m[string(key)]; ok { item,_ = m.Merge(o,item) }
+	m.m[string(key)] = item
+	return true
+}
+func (m *mem) Obtain(key []byte) ([]byte,bool,bool) {
+	m.lock.Lock(); defer m.lock.Unlock()
+	item,ok := m.m[string(key)]
+	return item,ok,true
+}
+func (m *mem) Stream(f func(key, item []byte)) {
+	m.lock.Lock()
+	keys := make([]string,0,len(m.m))
+	for k := range m.m { keys = append(keys,k) }
+	m.lock.Unlock()
+	sort.Strings(keys)
+	for _,k := range keys {
+		item,_,_ := m.Obtain([]byte(k))
+		f([]byte(k),item)
+	}
+}
+
+/* A minimal RESP client. */
+type client struct{
+	c net.Conn
+	r *bufio.Reader
+}
+func dial(t *testing.T, s *Server) *client {
+	l,err := net.Listen("tcp","127.0.0.1:0")
+	if err!=nil { t.Fatal(err) }
+	t.Cleanup(func(){ l.Close() })
+	go s.Serve(l)
+	c,err := net.Dial("tcp",l.Addr().String())
+	if err!=nil { t.Fatal(err) }
+	t.Cleanup(func(){ c.Close() })
+	c.SetDeadline(time.Now().Add(10*time.Second))
+	return &client{c,bufio.NewReader(c)}
+}
+func (c *client) raw(t *testing.T, s string) interface{} {
+	if _,err := c.c.Write([]byte(s)); err!=nil { t.Fatal(err) }
+	return c.reply(t)
+}
+func (c *client) do(t *testing.T, args ...string) interface{} {
+	var sb strings.Builder
+	fmt.Fprintf(&sb,"*%d\r\n",len(args))
+	for _,a := range args { fmt.Fprintf(&sb,"$%d\r\n%s\r\n",len(a),a) }
+	return c.raw(t,sb.String())
+}
+func (c *client) reply(t *testing.T) interface{} {
+	line,err := c.r.ReadString('\n')
+	if err!=nil { t.Fatal(err) }
+	line = strings.TrimRight(line,"\r\n")
+	switch line[0] {
+	case '+': return line[1:]
+	case '-': return fmt.Errorf("%s",line[1:])
+	case ':':
+		n,_ := strconv.ParseInt(line[1:],10,64)
+		return n
+	case '$':
+		n,_ := strconv.Atoi(line[1:])
+		if n<0 { return nil }
+		buf := make([]byte,n+2)
+		if _,err := io.ReadFull(c.r,buf); err!=nil { t.Fatal(err) }
+		return string(buf[:n])
+	case '*':
+		n,_ := strconv.Atoi(line[1:])
+		a := make([]interface{},n)
+		for i := range a { a[i] = c.reply(t) }
+		return a
+	}
+	t.Fatalf("bad reply %q",line)
+	return nil
+}
+
+func newServer() *Server {
+	return &Server{Api:newMem(datatypes.LWW_Factory),Counters:newMem(datatypes.Counter_Factory),Node:"n1"}
+}
+
+func TestRoundTrip(t *testing.T) {
+	c := dial(t,newServer())
+	steps := []struct{
+		args []string
+		want interface{}
+	}{
+		{[]string{"PING"},"PONG"},
+		{[]string{"SET","user/1","a"},"OK"},
+		{[]string{"MSET","b","2","c","3"},"OK"},
+		{[]string{"GET","user/1"},"a"},
+		{[]string{"MGET","user/1","x","b"},[]interface{}{"a",nil,"2"}},
+		{[]string{"DEL","b","x"},int64(1)},
+		{[]string{"EXISTS","b","c"},int64(1)},
+		{[]string{"INCRBY","cnt","5"},int64(5)},
+		{[]string{"DECR","cnt"},int64(4)},
+		{[]string{"GET","cnt"},"4"},
+		{[]string{"SCAN","0","MATCH","us?r/[0-9]"},[]interface{}{"0",[]interface{}{"user/1"}}},
+		{[]string{"SCAN","0","MATCH","c*"},[]interface{}{"0",[]interface{}{"c","cnt"}}},
+		{[]string{"DEL","cnt"},int64(1)},
+		{[]string{"GET","cnt"},nil},
+		{[]string{"EXISTS","cnt"},int64(0)},
+		{[]string{"SCAN","0","MATCH","c*"},[]interface{}{"0",[]interface{}{"c"}}},
+		{[]string{"INCR","cnt"},int64(1)},
+		{[]string{"INCR","c"},int64(1)},
+		{[]string{"SCAN","0","MATCH","c*"},[]interface{}{"0",[]interface{}{"c","cnt"}}},
+		{[]string{"GET","c"},"3"},
+	}
+	for _,st := range steps {
+		if got := c.do(t,st.args...); !reflect.DeepEqual(got,st.want) {
+			t.Fatalf("%v: got %#v, want %#v",st.args,got,st.want)
+		}
+	}
+	if got := c.raw(t,"PING\r\n"); got!="PONG" { t.Fatalf("inline: %#v",got) }
+	if _,ok := c.do(t,"FOO").(error); !ok { t.Fatal("unknown command must fail") }
+}
+
+func TestScanCursor(t *testing.T) {
+	c := dial(t,newServer())
+	for i := 0; i<25; i++ { c.do(t,"SET",fmt.Sprint("k",i),"v") }
+	seen := map[string]bool{}
+	cursor := "0"
+	for {
+		r := c.do(t,"SCAN",cursor,"COUNT","7").([]interface{})
+		for _,k := range r[1].([]interface{}) { seen[k.(string)] = true }
+		cursor = r[0].(string)
+		if cursor=="0" { break }
+	}
+	if len(seen)!=25 { t.Fatalf("scanned %d keys",len(seen)) }
+}
+
+func TestMalformed(t *testing.T) {
+	s := newServer()
+	s.MaxBulk = 16
+	for _,in := range []string{
+		"*-5\r\n",
+		"*9999999999\r\n",
+		"*1\r\n$-3\r\n",
+		"*1\r\n$17\r\n",
+		"*1\r\n+PING\r\n",
+	} {
+		c := dial(t,s)
+		if _,ok := c.raw(t,in).(error); !ok { t.Fatalf("%q must fail",in) }
+	}
+	c := dial(t,s)
+	if got := c.raw(t,"*-1\r\n*1\r\n$4\r\nPING\r\n"); got!="PONG" { t.Fatalf("null array: %#v",got) }
+	if got := c.do(t,"SET","k",strings.Repeat("x",16)); got!="OK" { t.Fatalf("%#v",got) }
+}
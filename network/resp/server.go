@@ -0,0 +1,301 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package resp
+
+import "github.com/byte-mug/brute/api"
+import "github.com/byte-mug/brute/datatypes"
+import "github.com/dgryski/go-farm"
+import "bufio"
+import "net"
+import "strconv"
+import "strings"
+import "sync"
+
+const nLocks = 64
+
+/*
+A server speaking the Redis protocol (RESP), mapping the commands onto a
+LWW StorageFacade:
+
+	PING ECHO QUIT COMMAND GET SET DEL EXISTS MGET MSET SCAN
+
+If Counters is set, INCR, INCRBY, DECR and DECRBY are supported as well.
+GET, EXISTS and SCAN also see counters, unless a string with the same key
+exists. As a counter can not be removed, DEL resets it to 0, and counters
+with the value 0 are treated as absent.
+*/
+type Server struct{
+	/* The key-value store, merged by datatypes.LWW_Factory. */
+	Api      api.StorageFacade
+	
+	/* Optional: a store merged by datatypes.Counter_Factory. */
+	Counters api.StorageFacade
+	
+	/* The name of this node, used for counter updates. Must be unique. */
+	Node     string
+	
+	/* The maximum size of a bulk string. If 0, DefaultMaxBulk is used. */
+	MaxBulk  int
+	
+	locks    [nLocks]sync.Mutex
+}
+
+/* Accepts connections and serves them. */
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		c,err := l.Accept()
+		if err!=nil { return err }
+		go s.ServeConn(c)
+	}
+}
+
+func (s *Server) ServeConn(c net.Conn) {
+	defer c.Close()
+	r := bufio.NewReader(c)
+	w := writer{bufio.NewWriter(c)}
+	maxBulk := s.MaxBulk
+	if maxBulk<=0 { maxBulk = DefaultMaxBulk }
+	for {
+		args,err := readCommand(r,maxBulk)
+		if err!=nil {
+			if err==errProtocol {
+				w.error("ERR Protocol error")
+				w.Flush()
+			}
+			return
+		}
+		if len(args)==0 { continue }
+		if !s.exec(w,args) {
+			w.Flush()
+			return
+		}
+		if r.Buffered()==0 && w.Flush()!=nil { return }
+	}
+}
+
+func (s *Server) get(key []byte) (value []byte,ok,readable bool) {
+	item,found,readable := s.Api.Obtain(key)
+	if !readable { return }
+	value,ok = datatypes.LWW_Decode(item,found,readable)
+	if ok || s.Counters==nil { return }
+	item,found,readable = s.Counters.Obtain(key)
+	if !readable { return }
+	n,ok := datatypes.Counter_Decode(item,found,readable)
+	ok = ok && n!=0
+	if ok { value = []byte(strconv.FormatInt(n,10)) }
+	return
+}
+func (s *Server) isString(key []byte) bool {
+	_,ok := datatypes.LWW_Decode(s.Api.Obtain(key))
+	return ok
+}
+func (s *Server) exists(key []byte) bool {
+	_,ok,_ := s.get(key)
+	return ok
+}
+func (s *Server) incr(key []byte, delta int64) (int64,bool) {
+	lock := &s.locks[farm.Hash32(key)%nLocks]
+	lock.Lock(); defer lock.Unlock()
+	item,ok,readable := s.Counters.Obtain(key)
+	if !readable { return 0,false }
+	if !ok { item = nil }
+	if !s.Counters.Submit(key,datatypes.Counter_Add(item,s.Node,delta)) { return 0,false }
+	return datatypes.Counter_Decode(s.Counters.Obtain(key))
+}
+
+/* Resets a counter to 0. Returns true, if it was not 0 before. */
+func (s *Server) reset(key []byte) bool {
+	lock := &s.locks[farm.Hash32(key)%nLocks]
+	lock.Lock(); defer lock.Unlock()
+	item,ok,readable := s.Counters.Obtain(key)
+	n,ok := datatypes.Counter_Decode(item,ok,readable)
+	if !ok || n==0 { return false }
+	return s.Counters.Submit(key,datatypes.Counter_Add(item,s.Node,-n))
+}
+
+/* Returns false, if the connection is to be closed. */
+func (s *Server) exec(w writer, args [][]byte) bool {
+	cmd := strings.ToUpper(string(args[0]))
+	args = args[1:]
+	arity := func(min int, even bool) bool {
+		if len(args)<min || (even && len(args)%2!=0) {
+			w.error("ERR wrong number of arguments for '"+strings.ToLower(cmd)+"' command")
+			return false
+		}
+		return true
+	}
+	switch cmd {
+	case "PING":
+		if len(args)>0 { w.bulk(args[0]) } else { w.simple("PONG") }
+	case "ECHO":
+		if !arity(1,false) { break }
+		w.bulk(args[0])
+	case "QUIT":
+		w.simple("OK")
+		return false
+	case "COMMAND":
+		w.array(0)
+	case "GET":
+		if !arity(1,false) { break }
+		value,ok,readable := s.get(args[0])
+		switch {
+		case !readable: w.error("ERR store not readable")
+		case !ok: w.null()
+		default: w.bulk(value)
+		}
+	case "SET":
+		if !arity(2,false) { break }
+		if len(args)>2 {
+			w.error("ERR syntax error")
+			break
+		}
+		if s.Api.Submit(args[0],datatypes.LWW_Put(args[1])) {
+			w.simple("OK")
+		} else {
+			w.error("ERR store not writable")
+		}
+	case "MSET":
+		if !arity(2,true) { break }
+		ok := true
+		for i := 0; i<len(args); i+=2 {
+			ok = s.Api.Submit(args[i],datatypes.LWW_Put(args[i+1])) && ok
+		}
+		if ok { w.simple("OK") } else { w.error("ERR store not writable") }
+	case "MGET":
+		if !arity(1,false) { break }
+		w.array(len(args))
+		for _,key := range args {
+			if value,ok,_ := s.get(key); ok { w.bulk(value) } else { w.null() }
+		}
+	case "DEL":
+		if !arity(1,false) { break }
+		var n int64
+		for _,key := range args {
+			deleted := false
+			if s.isString(key) && s.Api.Submit(key,datatypes.LWW_Delete()) { deleted = true }
+			if s.Counters!=nil && s.reset(key) { deleted = true }
+			if deleted { n++ }
+		}
+		w.integer(n)
+	case "EXISTS":
+		if !arity(1,false) { break }
+		var n int64
+		for _,key := range args {
+			if s.exists(key) { n++ }
+		}
+		w.integer(n)
+	case "SCAN":
+		if !arity(1,false) { break }
+		s.scan(w,args)
+	case "INCR","DECR","INCRBY","DECRBY":
+		if s.Counters==nil {
+			w.error("ERR counters are not configured")
+			break
+		}
+		var delta int64 = 1
+		if strings.HasSuffix(cmd,"BY") {
+			if !arity(2,false) { break }
+			var err error
+			delta,err = strconv.ParseInt(string(args[1]),10,64)
+			if err!=nil {
+				w.error("ERR value is not an integer or out of range")
+				break
+			}
+		} else if !arity(1,false) {
+			break
+		}
+		if strings.HasPrefix(cmd,"DECR") { delta = -delta }
+		if n,ok := s.incr(args[0],delta); ok { w.integer(n) } else { w.error("ERR store not writable") }
+	default:
+		w.error("ERR unknown command '"+strings.ToLower(cmd)+"'")
+	}
+	return true
+}
+
+/*
+SCAN cursor [MATCH pattern] [COUNT count]
+
+The cursor is the number of keys examined so far, in the order of the Stream
+of Api, followed by the one of Counters.
+*/
+func (s *Server) scan(w writer, args [][]byte) {
+	cursor,err := strconv.Atoi(string(args[0]))
+	if err!=nil || cursor<0 {
+		w.error("ERR invalid cursor")
+		return
+	}
+	pattern := ""
+	count := 10
+	for i := 1; i<len(args); i+=2 {
+		if i+1>=len(args) {
+			w.error("ERR syntax error")
+			return
+		}
+		switch strings.ToUpper(string(args[i])) {
+		case "MATCH":
+			pattern = string(args[i+1])
+		case "COUNT":
+			count,err = strconv.Atoi(string(args[i+1]))
+			if err!=nil || count<1 {
+				w.error("ERR syntax error")
+				return
+			}
+		default:
+			w.error("ERR syntax error")
+			return
+		}
+	}
+	
+	var keys, counters [][]byte
+	pos,next := 0,0
+	visit := func(key []byte, live bool) bool {
+		pos++
+		if pos<=cursor || next!=0 { return false }
+		if pos-cursor>=count { next = pos }
+		if pattern!="" {
+			if !match(pattern,string(key)) { return false }
+		}
+		return live
+	}
+	s.Api.Stream(func(key, item []byte){
+		_,ok := datatypes.LWW_Decode(item,true,true)
+		if visit(key,ok) { keys = append(keys,append([]byte(nil),key...)) }
+	})
+	if s.Counters!=nil {
+		s.Counters.Stream(func(key, item []byte){
+			n,ok := datatypes.Counter_Decode(item,true,true)
+			if visit(key,ok && n!=0) { counters = append(counters,append([]byte(nil),key...)) }
+		})
+	}
+	
+	/* Counters, that are shadowed by a string, are dropped after the Stream, so that Api is not read during it. */
+	for _,key := range counters {
+		if !s.isString(key) { keys = append(keys,key) }
+	}
+	if next==pos { next = 0 }
+	w.array(2)
+	w.bulk([]byte(strconv.Itoa(next)))
+	w.array(len(keys))
+	for _,key := range keys { w.bulk(key) }
+}
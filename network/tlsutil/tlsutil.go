@@ -0,0 +1,100 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package tlsutil
+
+import "net/http"
+
+import "crypto/tls"
+import "crypto/x509"
+import "errors"
+import "io/ioutil"
+import "net"
+
+var ErrNoCerts = errors.New("tlsutil: no certificates found")
+
+func loadPool(file string) (*x509.CertPool,error) {
+	pem,err := ioutil.ReadFile(file)
+	if err!=nil { return nil,err }
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) { return nil,ErrNoCerts }
+	return pool,nil
+}
+
+/*
+Creates a server-side TLS configuration from PEM files.
+
+If clientCAFile is not empty, clients must present a certificate signed by one
+of the CAs in it (mutual TLS).
+*/
+func ServerConfig(certFile, keyFile, clientCAFile string) (*tls.Config,error) {
+	cert,err := tls.LoadX509KeyPair(certFile,keyFile)
+	if err!=nil { return nil,err }
+	cfg := &tls.Config{Certificates:[]tls.Certificate{cert},MinVersion:tls.VersionTLS12}
+	if clientCAFile!="" {
+		cfg.ClientCAs,err = loadPool(clientCAFile)
+		if err!=nil { return nil,err }
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return cfg,nil
+}
+
+/*
+Creates a client-side TLS configuration from PEM files.
+
+If caFile is empty, the system roots are used. If certFile and keyFile are not
+empty, the client presents that certificate (mutual TLS).
+*/
+func ClientConfig(caFile, certFile, keyFile string) (*tls.Config,error) {
+	cfg := &tls.Config{MinVersion:tls.VersionTLS12}
+	var err error
+	if caFile!="" {
+		cfg.RootCAs,err = loadPool(caFile)
+		if err!=nil { return nil,err }
+	}
+	if certFile!="" || keyFile!="" {
+		cert,err := tls.LoadX509KeyPair(certFile,keyFile)
+		if err!=nil { return nil,err }
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg,nil
+}
+
+/* Creates a http.Client, suitable as Shared client of httpi.Client or httpi.Syncer. */
+func HTTPClient(cfg *tls.Config) *http.Client {
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.TLSClientConfig = cfg
+	return &http.Client{Transport:tr}
+}
+
+/* Listens on a TCP address, accepting TLS connections only. */
+func Listen(addr string, cfg *tls.Config) (net.Listener,error) {
+	return tls.Listen("tcp",addr,cfg)
+}
+
+/* Serves the handler (eg. a httprouter.Router) over TLS. */
+func ListenAndServe(addr string, cfg *tls.Config, h http.Handler) error {
+	l,err := Listen(addr,cfg)
+	if err!=nil { return err }
+	return (&http.Server{Handler:h,TLSConfig:cfg}).Serve(l)
+}
@@ -0,0 +1,122 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package tlsutil
+
+import "github.com/byte-mug/brute/network/auth"
+import "crypto/ecdsa"
+import "crypto/elliptic"
+import "crypto/rand"
+import "crypto/x509"
+import "crypto/x509/pkix"
+import "encoding/pem"
+import "io/ioutil"
+import "log"
+import "math/big"
+import "net"
+import "net/http"
+import "os"
+import "path/filepath"
+import "testing"
+import "time"
+
+/* Creates a certificate signed by ca (self-signed, if ca is nil) and writes it to dir. */
+func mkCert(t *testing.T, dir, name string, ca *x509.Certificate, caKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key,err := ecdsa.GenerateKey(elliptic.P256(),rand.Reader)
+	if err!=nil { t.Fatal(err) }
+	tpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject: pkix.Name{CommonName:name},
+		NotBefore: time.Now().Add(-time.Hour),
+		NotAfter: time.Now().Add(time.Hour),
+		IsCA: ca==nil,
+		BasicConstraintsValid: true,
+		KeyUsage: x509.KeyUsageDigitalSignature|x509.KeyUsageCertSign,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth,x509.ExtKeyUsageClientAuth},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	if ca==nil { ca,caKey = tpl,key }
+	der,err := x509.CreateCertificate(rand.Reader,tpl,ca,&key.PublicKey,caKey)
+	if err!=nil { t.Fatal(err) }
+	kb,err := x509.MarshalECPrivateKey(key)
+	if err!=nil { t.Fatal(err) }
+	write := func(file, typ string, b []byte) {
+		if err := ioutil.WriteFile(filepath.Join(dir,file),pem.EncodeToMemory(&pem.Block{Type:typ,Bytes:b}),0600); err!=nil { t.Fatal(err) }
+	}
+	write(name+".crt","CERTIFICATE",der)
+	write(name+".key","EC PRIVATE KEY",kb)
+	cert,err := x509.ParseCertificate(der)
+	if err!=nil { t.Fatal(err) }
+	return cert,key
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca,caKey := mkCert(t,dir,"ca",nil,nil)
+	mkCert(t,dir,"server",ca,caKey)
+	mkCert(t,dir,"node2",ca,caKey)
+	f := func(name string) string { return filepath.Join(dir,name) }
+	
+	scfg,err := ServerConfig(f("server.crt"),f("server.key"),f("ca.crt"))
+	if err!=nil { t.Fatal(err) }
+	l,err := Listen("127.0.0.1:0",scfg)
+	if err!=nil { t.Fatal(err) }
+	a := &auth.Authenticator{Peers:map[string]auth.Perm{"node2":auth.Read}}
+	srv := &http.Server{ErrorLog:log.New(ioutil.Discard,"",0),Handler:http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if code := a.Authorize(r,auth.Read); code!=0 {
+			w.WriteHeader(code)
+			return
+		}
+		w.Write([]byte(auth.PeerIdentity(r)))
+	})}
+	go srv.Serve(l)
+	defer srv.Close()
+	url := "https://"+l.Addr().String()+"/"
+	
+	ccfg,err := ClientConfig(f("ca.crt"),f("node2.crt"),f("node2.key"))
+	if err!=nil { t.Fatal(err) }
+	resp,err := HTTPClient(ccfg).Get(url)
+	if err!=nil { t.Fatal(err) }
+	body,_ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode!=200 || string(body)!="node2" { t.Fatalf("status %d, identity %q",resp.StatusCode,body) }
+	
+	/* Without a client certificate, the handshake fails. */
+	ncfg,err := ClientConfig(f("ca.crt"),"","")
+	if err!=nil { t.Fatal(err) }
+	if resp,err = HTTPClient(ncfg).Get(url); err==nil {
+		resp.Body.Close()
+		t.Fatal("request without client certificate succeeded")
+	}
+	
+	/* A server certificate from another CA is rejected. */
+	other := filepath.Join(dir,"other")
+	if err = os.Mkdir(other,0700); err!=nil { t.Fatal(err) }
+	mkCert(t,other,"ca",nil,nil)
+	ocfg,err := ClientConfig(filepath.Join(other,"ca.crt"),f("node2.crt"),f("node2.key"))
+	if err!=nil { t.Fatal(err) }
+	if resp,err = HTTPClient(ocfg).Get(url); err==nil {
+		resp.Body.Close()
+		t.Fatal("untrusted server certificate accepted")
+	}
+}
@@ -0,0 +1,220 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package wire
+
+import "github.com/byte-mug/brute/api"
+import "bufio"
+import "errors"
+import "net"
+import "sync"
+import "sync/atomic"
+import "time"
+
+/* The default value for Client.Conns. */
+const DefaultConns = 4
+
+var ErrClosed = errors.New("wire: connection closed")
+
+type conn struct{
+	nc      net.Conn
+	wlock   sync.Mutex
+	bw      *bufio.Writer
+	
+	lock    sync.Mutex
+	pending map[uint64]chan *frame
+	broken  bool
+}
+func (c *conn) fail() {
+	c.lock.Lock(); defer c.lock.Unlock()
+	c.broken = true
+	c.nc.Close()
+}
+
+/* Only the reader closes the channels, so that it never sends on a closed one. */
+func (c *conn) readerExit() {
+	c.lock.Lock(); defer c.lock.Unlock()
+	c.broken = true
+	c.nc.Close()
+	for id,ch := range c.pending {
+		close(ch)
+		delete(c.pending,id)
+	}
+}
+func (c *conn) reader(max int) {
+	defer c.readerExit()
+	br := bufio.NewReader(c.nc)
+	for {
+		f := new(frame)
+		if readFrame(br,max,f)!=nil { return }
+		c.lock.Lock()
+		ch := c.pending[f.id]
+		if f.code!=stItem { delete(c.pending,f.id) }
+		c.lock.Unlock()
+		if ch==nil { continue }
+		ch <- f
+		if f.code!=stItem { close(ch) }
+	}
+}
+func (c *conn) send(f *frame, buffer int) (chan *frame,error) {
+	ch := make(chan *frame,buffer)
+	c.lock.Lock()
+	if c.broken {
+		c.lock.Unlock()
+		return nil,ErrClosed
+	}
+	c.pending[f.id] = ch
+	c.lock.Unlock()
+	
+	c.wlock.Lock()
+	err := writeFrame(c.bw,f)
+	if err==nil { err = c.bw.Flush() }
+	c.wlock.Unlock()
+	if err!=nil {
+		c.fail()
+		return nil,err
+	}
+	return ch,nil
+}
+
+/* Drops a request, that is no longer waited for, eg. after a timeout. */
+func (c *conn) forget(id uint64) {
+	c.lock.Lock(); defer c.lock.Unlock()
+	delete(c.pending,id)
+}
+
+/*
+A StorageFacade, that talks the binary protocol to a Server, pipelining the
+requests over a small pool of connections.
+
+During a .Stream(), the other requests on the same connection wait until the
+streamed items are consumed. Hence, the Client must not be used from within
+the callback of .Stream(), as that may deadlock.
+*/
+type Client struct{
+	/* "tcp" or "unix". If "", "tcp" is used. */
+	Network  string
+	Addr     string
+	
+	/* Number of connections. If 0, DefaultConns is used. */
+	Conns    int
+	
+	/* Maximum size of a response frame. If 0, DefaultMaxFrame is used. */
+	MaxFrame int
+	
+	/* Optional: used to establish connections, eg. for TLS. */
+	Dial     func(network, addr string) (net.Conn,error)
+	
+	/* Optional: the timeout for Submit and Obtain. */
+	Timeout  time.Duration
+	
+	lock     sync.Mutex
+	conns    []*conn
+	next     uint32
+	ids      uint64
+}
+func (c *Client) dial() (*conn,error) {
+	network := c.Network
+	if network=="" { network = "tcp" }
+	dial := c.Dial
+	if dial==nil { dial = net.Dial }
+	nc,err := dial(network,c.Addr)
+	if err!=nil { return nil,err }
+	max := c.MaxFrame
+	if max<=0 { max = DefaultMaxFrame }
+	cn := &conn{nc:nc,bw:bufio.NewWriter(nc),pending:make(map[uint64]chan *frame)}
+	go cn.reader(max)
+	return cn,nil
+}
+func (c *Client) get() (*conn,error) {
+	n := c.Conns
+	if n<=0 { n = DefaultConns }
+	i := int(atomic.AddUint32(&c.next,1)%uint32(n))
+	c.lock.Lock(); defer c.lock.Unlock()
+	if c.conns==nil { c.conns = make([]*conn,n) }
+	cn := c.conns[i]
+	if cn!=nil {
+		cn.lock.Lock()
+		broken := cn.broken
+		cn.lock.Unlock()
+		if !broken { return cn,nil }
+	}
+	cn,err := c.dial()
+	if err!=nil { return nil,err }
+	c.conns[i] = cn
+	return cn,nil
+}
+func (c *Client) call(op uint8, key, item []byte) *frame {
+	cn,err := c.get()
+	if err!=nil { return nil }
+	id := atomic.AddUint64(&c.ids,1)
+	ch,err := cn.send(&frame{id:id,code:op,key:key,item:item},1)
+	if err!=nil { return nil }
+	if c.Timeout<=0 { return <-ch }
+	t := time.NewTimer(c.Timeout)
+	defer t.Stop()
+	select {
+	case f := <-ch: return f
+	case <-t.C:
+		cn.forget(id)
+		return nil
+	}
+}
+
+/* Closes all connections. */
+func (c *Client) Close() {
+	c.lock.Lock(); defer c.lock.Unlock()
+	for _,cn := range c.conns {
+		if cn!=nil { cn.fail() }
+	}
+	c.conns = nil
+}
+
+func (c *Client) Submit(key, item []byte) (ok bool) {
+	f := c.call(opSubmit,key,item)
+	return f!=nil && f.code==stOK
+}
+func (c *Client) Obtain(key []byte) (item []byte,ok,readable bool) {
+	f := c.call(opObtain,key,nil)
+	if f==nil { return }
+	switch f.code {
+	case stOK:
+		return f.item,true,true
+	case stNotFound:
+		readable = true
+	}
+	return
+}
+/* Must not call the Client from within fn (see Client). */
+func (c *Client) Stream(fn func(key, item []byte)) {
+	cn,err := c.get()
+	if err!=nil { return }
+	ch,err := cn.send(&frame{id:atomic.AddUint64(&c.ids,1),code:opStream},128)
+	if err!=nil { return }
+	for f := range ch {
+		if f.code!=stItem { return }
+		fn(f.key,f.item)
+	}
+}
+
+var _ api.StorageFacade = (*Client)(nil)
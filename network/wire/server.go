@@ -0,0 +1,123 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package wire
+
+import "github.com/byte-mug/brute/api"
+import "bufio"
+import "net"
+import "sync"
+
+/* The default value for Server.Concurrency. */
+const DefaultConcurrency = 64
+
+/* Serves a StorageFacade over the binary protocol. */
+type Server struct{
+	Api         api.StorageFacade
+	
+	/* Maximum size of a request frame. If 0, DefaultMaxFrame is used. */
+	MaxFrame    int
+	
+	/* Maximum number of requests processed concurrently per connection. If 0, DefaultConcurrency is used. */
+	Concurrency int
+}
+
+/* Accepts connections (TCP or Unix sockets) and serves them. */
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		c,err := l.Accept()
+		if err!=nil { return err }
+		go s.ServeConn(c)
+	}
+}
+
+func (s *Server) ServeConn(c net.Conn) {
+	defer c.Close()
+	max := s.MaxFrame
+	if max<=0 { max = DefaultMaxFrame }
+	conc := s.Concurrency
+	if conc<=0 { conc = DefaultConcurrency }
+	
+	out := make(chan *frame,conc)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		bw := bufio.NewWriter(c)
+		failed := false
+		for f := range out {
+			if failed { continue }
+			err := writeFrame(bw,f)
+			if err==nil && len(out)==0 { err = bw.Flush() }
+			if err!=nil {
+				/* Unblocks the reader; the remaining responses are discarded. */
+				failed = true
+				c.Close()
+			}
+		}
+	}()
+	
+	var wg sync.WaitGroup
+	sem := make(chan struct{},conc)
+	br := bufio.NewReader(c)
+	for {
+		f := new(frame)
+		if readFrame(br,max,f)!=nil { break }
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			s.handle(f,out)
+		}()
+	}
+	wg.Wait()
+	close(out)
+	<-done
+}
+func (s *Server) handle(f *frame, out chan<- *frame) {
+	switch f.code {
+	case opSubmit:
+		st := stOK
+		if !s.Api.Submit(f.key,f.item) { st = stFail }
+		out <- &frame{id:f.id,code:st}
+	case opObtain:
+		item,ok,readable := s.Api.Obtain(f.key)
+		switch {
+		case !readable: out <- &frame{id:f.id,code:stFail}
+		case !ok:       out <- &frame{id:f.id,code:stNotFound}
+		default:        out <- &frame{id:f.id,code:stOK,item:append([]byte(nil),item...)}
+		}
+	case opStream:
+		s.Api.Stream(func(key, item []byte){
+			out <- &frame{
+				id:   f.id,
+				code: stItem,
+				key:  append([]byte(nil),key...),
+				item: append([]byte(nil),item...),
+			}
+		})
+		out <- &frame{id:f.id,code:stEnd}
+	default:
+		out <- &frame{id:f.id,code:stFail}
+	}
+}
@@ -0,0 +1,92 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package wire
+
+import "github.com/vmihailenco/msgpack"
+import "encoding/binary"
+import "bytes"
+import "errors"
+import "io"
+
+/*
+Every frame is a 4-byte big endian length, followed by a msgpack-encoded
+sequence of that length:
+
+	request  => (id,op,key,item)
+	response => (id,status,key,item)
+
+Requests are pipelined: a client may send many requests without waiting for
+the responses, which are matched by the request id and may arrive out of
+order. A stream request is answered with any number of stItem responses,
+followed by a stEnd response.
+*/
+const (
+	opSubmit uint8 = 1+iota
+	opObtain
+	opStream
+)
+
+const (
+	stOK uint8 = iota
+	stNotFound
+	stFail
+	stItem
+	stEnd
+)
+
+/* The default maximum frame size. The frame is read incrementally up to this size. */
+const DefaultMaxFrame = 64<<20
+
+var ErrFrameSize = errors.New("wire: frame too large")
+
+type frame struct{
+	id   uint64
+	code uint8
+	key  []byte
+	item []byte
+}
+
+func writeFrame(w io.Writer, f *frame) error {
+	var buf bytes.Buffer
+	buf.Write([]byte{0,0,0,0})
+	if err := msgpack.NewEncoder(&buf).EncodeMulti(f.id,f.code,f.key,f.item); err!=nil { return err }
+	b := buf.Bytes()
+	binary.BigEndian.PutUint32(b,uint32(len(b)-4))
+	_,err := w.Write(b)
+	return err
+}
+func readFrame(r io.Reader, max int, f *frame) error {
+	var hdr [4]byte
+	if _,err := io.ReadFull(r,hdr[:]); err!=nil { return err }
+	n := binary.BigEndian.Uint32(hdr[:])
+	if int64(n)>int64(max) { return ErrFrameSize }
+	
+	/* Read incrementally, so that the memory used grows with the data actually received. */
+	var buf bytes.Buffer
+	m,err := buf.ReadFrom(io.LimitReader(r,int64(n)))
+	if err!=nil { return err }
+	if m<int64(n) { return io.ErrUnexpectedEOF }
+	f.key,f.item = nil,nil
+	return msgpack.NewDecoder(&buf).DecodeMulti(&f.id,&f.code,&f.key,&f.item)
+}
@@ -0,0 +1,143 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package wire
+
+import "github.com/byte-mug/brute/datatypes"
+import "github.com/byte-mug/brute/utils"
+import "github.com/byte-mug/brute/api"
+import "bytes"
+import "fmt"
+import "io"
+import "io/ioutil"
+import "net"
+import "path/filepath"
+import "sort"
+import "sync"
+import "testing"
+import "time"
+
+/* An in-memory StorageFacade. */
+type mem struct{
+	utils.MergeUtil
+	lock sync.Mutex
+	m    map[string][]byte
+}
+func newMem(f api.MergerFactory) *mem {
+	m := &mem{m:make(map[string][]byte)}
+	m.Merger = f
+	return m
+}
+func (m *mem) Submit(key, item []byte) bool {
+	m.lock.Lock(); defer m.lock.Unlock()
+	if o,ok := m.m[string(key)]; ok { item,_ = m.Merge(o,item) }
+	m.m[string(key)] = item
+	return true
+}
+func (m *mem) Obtain(key []byte) ([]byte,bool,bool) {
+	m.lock.Lock(); defer m.lock.Unlock()
+	item,ok := m.m[string(key)]
+	return item,ok,true
+}
+func (m *mem) Stream(f func(key, item []byte)) {
+	m.lock.Lock()
+	keys := make([]string,0,len(m.m))
+	for k := range m.m { keys = append(keys,k) }
+	m.lock.Unlock()
+	sort.Strings(keys)
+	for _,k := range keys {
+		item,_,_ := m.Obtain([]byte(k))
+		f([]byte(k),item)
+	}
+}
+
+func TestPipelined(t *testing.T) {
+	for _,nw := range []string{"tcp","unix"} {
+		addr := "127.0.0.1:0"
+		if nw=="unix" { addr = filepath.Join(t.TempDir(),"sock") }
+		l,err := net.Listen(nw,addr)
+		if err!=nil { t.Fatal(err) }
+		go (&Server{Api:newMem(datatypes.LWW_Factory)}).Serve(l)
+		c := &Client{Network:nw,Addr:l.Addr().String(),Timeout:10*time.Second}
+		
+		var wg sync.WaitGroup
+		for i := 0; i<200; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				key := []byte(fmt.Sprint(i))
+				if !c.Submit(key,datatypes.LWW_Put(key)) { t.Errorf("%s: submit failed",nw) }
+				v,ok := datatypes.LWW_Decode(c.Obtain(key))
+				if !ok || string(v)!=string(key) { t.Errorf("%s: %d: got %q",nw,i,v) }
+			}(i)
+		}
+		wg.Wait()
+		n := 0
+		c.Stream(func(key, item []byte){ n++ })
+		if n!=200 { t.Errorf("%s: streamed %d items, want 200",nw,n) }
+		if _,ok,readable := c.Obtain([]byte("missing")); ok || !readable { t.Errorf("%s: missing key: %v %v",nw,ok,readable) }
+		
+		c.Close()
+		l.Close()
+		if c.Submit([]byte("a"),nil) { t.Errorf("%s: submit succeeded without server",nw) }
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	l,err := net.Listen("tcp","127.0.0.1:0")
+	if err!=nil { t.Fatal(err) }
+	defer l.Close()
+	go func() {
+		/* Reads the requests, but never answers. */
+		for {
+			nc,err := l.Accept()
+			if err!=nil { return }
+			go io.Copy(ioutil.Discard,nc)
+		}
+	}()
+	c := &Client{Addr:l.Addr().String(),Conns:1,Timeout:20*time.Millisecond}
+	defer c.Close()
+	for i := 0; i<3; i++ {
+		if _,_,readable := c.Obtain([]byte("a")); readable { t.Fatal("obtain succeeded") }
+	}
+	cn := c.conns[0]
+	cn.lock.Lock()
+	n := len(cn.pending)
+	cn.lock.Unlock()
+	if n!=0 { t.Fatalf("%d requests pending after timeouts",n) }
+}
+
+func TestFrameSize(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeFrame(&buf,&frame{id:1,code:stOK,key:[]byte("k"),item:make([]byte,100)}); err!=nil { t.Fatal(err) }
+	b := buf.Bytes()
+	
+	f := new(frame)
+	if err := readFrame(bytes.NewReader(b),len(b),f); err!=nil || f.id!=1 || len(f.item)!=100 { t.Fatalf("got %v %+v",err,f) }
+	if err := readFrame(bytes.NewReader(b),50,f); err!=ErrFrameSize { t.Fatalf("got %v, want ErrFrameSize",err) }
+	
+	/* A header announcing a large frame, that never arrives. */
+	if err := readFrame(bytes.NewReader([]byte{0,0x10,0,0,1,2,3}),DefaultMaxFrame,f); err!=io.ErrUnexpectedEOF {
+		t.Fatalf("got %v, want io.ErrUnexpectedEOF",err)
+	}
+}
@@ -94,12 +94,12 @@ func (q *BoltLocalUpdateLog) ReadAllAsync(since time.Time, targ chan <- replicat
 	tmb := since.UTC().AppendFormat(nil,TSF)
 	go q.DB.View(func(tx *bolt.Tx) (e2 error) {
 		defer close(chr)
+		defer close(targ)
 		idx := tx.Bucket(q.Index)
 		if idx==nil { return nil } // Nothing to return!
 		bkt := tx.Bucket(q.Table)
 		cur := idx.Cursor()
 		chr <- nil
-		defer close(targ)
 		for k,v := cur.Seek(tmb); len(k)!=0; k,v = cur.Next() {
 			nv := make([]byte,len(v))
 			copy(nv,v)
@@ -108,7 +108,7 @@ func (q *BoltLocalUpdateLog) ReadAllAsync(since time.Time, targ chan <- replicat
 				if bkt==nil { continue }
 				if msgpack.Unmarshal(bkt.Get(v),&t)!=nil { continue }
 			}
-			targ <- replicator.LocalUpdateEntry{ Key: v, Change: t, Exist: true}
+			targ <- replicator.LocalUpdateEntry{ Key: nv, Change: t, Exist: true}
 		}
 		return nil
 	})
@@ -28,6 +28,7 @@ import "net/http"
 
 import "github.com/byte-mug/brute/replicator"
 import "github.com/byte-mug/brute/api"
+import "github.com/byte-mug/brute/network/auth"
 import "encoding/base64"
 import "github.com/vmihailenco/msgpack"
 import "bytes"
@@ -39,6 +40,9 @@ type Server struct {
 	Vec replicator.TimeVec
 	Log replicator.LocalUpdateLog
 	Api api.StorageFacade
+	
+	/* Optional: if set, requests must be authenticated with the Replicate permission. */
+	Auth *auth.Authenticator
 }
 func (s *Server) getSince(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	bts,err := base64.RawURLEncoding.DecodeString(ps.ByName("since"))
@@ -82,8 +86,8 @@ func (s *Server) getVersion(w http.ResponseWriter, r *http.Request, ps httproute
 	enc.EncodeMulti(tvq.Exist,tvq.Value)
 }
 func (s *Server) Register(r *httprouter.Router) {
-	r.GET("/"+s.DBN+"/p2p-s/:since",s.getSince  )
-	r.GET("/"+s.DBN+"/p2p-v"       ,s.getVersion)
+	r.GET("/"+s.DBN+"/p2p-s/:since",s.Auth.Guard(auth.Replicate,s.getSince  ))
+	r.GET("/"+s.DBN+"/p2p-v"       ,s.Auth.Guard(auth.Replicate,s.getVersion))
 }
 
 
@@ -27,9 +27,11 @@ import "net/http"
 
 import "github.com/byte-mug/brute/replicator"
 import "github.com/byte-mug/brute/api"
+import "github.com/byte-mug/brute/network/auth"
 import "encoding/base64"
 import "github.com/vmihailenco/msgpack"
 import "bufio"
+import "fmt"
 import "time"
 
 func tmMax(a, b time.Time) time.Time {
@@ -53,17 +55,58 @@ func finishBatch(s api.StorageFacade) error {
 	return nil
 }
 
+/* Callbacks, that are invoked by the Syncer, eg. for instrumentation. */
+type SyncHooks interface{
+	/* Called after .GetVersion() */
+	Version(node string, d time.Duration, err error)
+	
+	/* Called after .SyncWith() with the number of items received. */
+	Synced(node string, items int, d time.Duration, err error)
+}
+
+/*
+Peer addresses are either "host:port" or base URLs, such as "https://host:port".
+*/
 type Syncer struct {
 	DBN string
 	Shared *http.Client
+	
+	/* The URL scheme used with "host:port" addresses. If "", "http" is used. */
+	Scheme string
+
 	Vec replicator.TimeVec
 	Api api.StorageFacade
+	
+	/* Optional. */
+	Hooks SyncHooks
+	
+	/* Optional: the credentials to authenticate with. */
+	Auth auth.Credentials
 }
-func (s *Syncer) GetVersion(node,addr string) (*time.Time,error) {
+/* Performs a GET request. Responses other than 200, eg. 401 or 403, are errors. */
+func (s *Syncer) get(ue string) (*http.Response,error) {
+	req,err := http.NewRequest("GET",ue,nil)
+	if err!=nil { return nil,err }
+	if err = auth.Sign(s.Auth,req); err!=nil { return nil,err }
+	resp,err := s.Shared.Do(req)
+	if err!=nil { return nil,err }
+	if resp.StatusCode!=200 {
+		resp.Body.Close()
+		return nil,fmt.Errorf("httpi: %s: status %d",ue,resp.StatusCode)
+	}
+	return resp,nil
+}
+func (s *Syncer) GetVersion(node,addr string) (t *time.Time,err error) {
+	if s.Hooks!=nil {
+		defer func(begin time.Time) { s.Hooks.Version(node,time.Since(begin),err) }(time.Now())
+	}
+	return s.getVersion(node,addr)
+}
+func (s *Syncer) getVersion(node,addr string) (*time.Time,error) {
 	var t time.Time
 	var exist bool
-	ue := "http://"+addr+"/"+s.DBN+"/p2p-v"
-	resp,err := s.Shared.Get(ue)
+	ue := auth.BaseURL(s.Scheme,addr)+"/"+s.DBN+"/p2p-v"
+	resp,err := s.get(ue)
 	if err!=nil { return nil,err }
 	defer resp.Body.Close()
 	dec := msgpack.NewDecoder(bufio.NewReader(resp.Body))
@@ -74,7 +117,14 @@ func (s *Syncer) GetVersion(node,addr string) (*time.Time,error) {
 	if exist { return &t,nil }
 	return nil,nil
 }
-func (s *Syncer) SyncWith(node,addr string, remote *time.Time) error {
+func (s *Syncer) SyncWith(node,addr string, remote *time.Time) (err error) {
+	var items int
+	if s.Hooks!=nil {
+		defer func(begin time.Time) { s.Hooks.Synced(node,items,time.Since(begin),err) }(time.Now())
+	}
+	return s.syncWith(node,addr,remote,&items)
+}
+func (s *Syncer) syncWith(node,addr string, remote *time.Time, items *int) error {
 	tvq := &replicator.TimeVecQuery{Node:node}
 	err := s.Vec.Query(tvq)
 	if err!=nil { return err }
@@ -85,8 +135,8 @@ func (s *Syncer) SyncWith(node,addr string, remote *time.Time) error {
 	
 	btm,err := msgpack.Marshal(tvq.Value)
 	if err!=nil { return err }
-	ue := "http://"+addr+"/"+s.DBN+"/p2p-s/"+base64.RawURLEncoding.EncodeToString(btm)
-	resp,err := s.Shared.Get(ue)
+	ue := auth.BaseURL(s.Scheme,addr)+"/"+s.DBN+"/p2p-s/"+base64.RawURLEncoding.EncodeToString(btm)
+	resp,err := s.get(ue)
 	if err!=nil { return err }
 	defer resp.Body.Close()
 	dec := msgpack.NewDecoder(bufio.NewReader(resp.Body))
@@ -102,6 +152,7 @@ func (s *Syncer) SyncWith(node,addr string, remote *time.Time) error {
 	for {
 		err = dec.DecodeMulti(i...)
 		if err!=nil { break }
+		*items++
 		if ok {
 			ok = batch.Submit(key,item)
 		} else {
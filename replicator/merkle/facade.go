@@ -0,0 +1,91 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package merkle
+
+import "github.com/byte-mug/brute/api"
+import "github.com/dgryski/go-farm"
+import "bytes"
+import "sync"
+
+const nLocks = 64
+
+/*
+Keeps a Tree up to date with the writes, that pass through it. Every .Submit()
+reads the item before and after the write, to update the Tree.
+
+All writes to Api must go through the Facade, including those of a Syncer.
+Api must yield plain items, eg. a codec.Facade rather than the store beneath
+it (see Tree).
+*/
+type Facade struct{
+	Api   api.StorageFacade
+	Tree  *Tree
+	
+	locks [nLocks]sync.Mutex
+}
+func (f *Facade) Submit(key, item []byte) (ok bool) {
+	lock := &f.locks[farm.Hash32(key)%nLocks]
+	lock.Lock(); defer lock.Unlock()
+	old,_,_ := f.Api.Obtain(key)
+	old = append([]byte(nil),old...)
+	ok = f.Api.Submit(key,item)
+	if !ok { return }
+	nw,_,_ := f.Api.Obtain(key)
+	if !bytes.Equal(old,nw) { f.Tree.Update(key,old,nw) }
+	return
+}
+func (f *Facade) Obtain(key []byte) (item []byte,ok,readable bool) {
+	return f.Api.Obtain(key)
+}
+func (f *Facade) Stream(fn func(key, item []byte)) {
+	f.Api.Stream(fn)
+}
+
+/* Removes a key, if Api implements api.Deleter. */
+func (f *Facade) Delete(key []byte) (ok bool) {
+	d,ok := f.Api.(api.Deleter)
+	if !ok { return false }
+	lock := &f.locks[farm.Hash32(key)%nLocks]
+	lock.Lock(); defer lock.Unlock()
+	old,_,_ := f.Api.Obtain(key)
+	old = append([]byte(nil),old...)
+	ok = d.Delete(key)
+	if ok { f.Tree.Update(key,old,nil) }
+	return
+}
+
+/* Removes a key, if its item equals 'item' and Api implements api.CompareDeleter. */
+func (f *Facade) DeleteIf(key, item []byte) (deleted bool) {
+	d,ok := f.Api.(api.CompareDeleter)
+	if !ok { return false }
+	lock := &f.locks[farm.Hash32(key)%nLocks]
+	lock.Lock(); defer lock.Unlock()
+	deleted = d.DeleteIf(key,item)
+	if deleted { f.Tree.Update(key,item,nil) }
+	return
+}
+
+var _ api.StorageFacade = (*Facade)(nil)
+var _ api.Deleter = (*Facade)(nil)
+var _ api.CompareDeleter = (*Facade)(nil)
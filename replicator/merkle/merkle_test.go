@@ -0,0 +1,157 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package merkle
+
+import "github.com/julienschmidt/httprouter"
+import "github.com/byte-mug/brute/datatypes"
+import "github.com/byte-mug/brute/utils"
+import "github.com/byte-mug/brute/api"
+import "fmt"
+import "net/http"
+import "net/http/httptest"
+import "sort"
+import "sync"
+import "testing"
+
+/* An in-memory StorageFacade. */
+type mem struct{
+	utils.MergeUtil
+	lock sync.Mutex
+	m    map[string][]byte
+}
+func newMem(f api.MergerFactory) *mem {
+	m := &mem{m:make(map[string][]byte)}
+	m.Merger = f
+	return m
+}
+func (m *mem) Submit(key, item []byte) bool {
+	m.lock.Lock(); defer m.lock.Unlock()
+	if o,ok := m.m[string(key)]; ok { item,_ = m.Merge(o,item) }
+	m.m[string(key)] = item
+	return true
+}
+func (m *mem) Obtain(key []byte) ([]byte,bool,bool) {
+	m.lock.Lock(); defer m.lock.Unlock()
+	item,ok := m.m[string(key)]
+	return item,ok,true
+}
+func (m *mem) Stream(f func(key, item []byte)) {
+	m.lock.Lock()
+	keys := make([]string,0,len(m.m))
+	for k := range m.m { keys = append(keys,k) }
+	m.lock.Unlock()
+	sort.Strings(keys)
+	for _,k := range keys {
+		item,_,_ := m.Obtain([]byte(k))
+		f([]byte(k),item)
+	}
+}
+func (m *mem) Delete(key []byte) bool {
+	m.lock.Lock(); defer m.lock.Unlock()
+	delete(m.m,string(key))
+	return true
+}
+
+type replica struct{
+	store  *mem
+	facade *Facade
+	url    string
+	syncer *Syncer
+}
+func newReplica(t *testing.T) *replica {
+	r := &replica{store:newMem(datatypes.Table_Factory)}
+	r.facade = &Facade{Api:r.store,Tree:New(6)}
+	router := httprouter.New()
+	(&Server{DBN:"db",Tree:r.facade.Tree,Api:r.facade}).Register(router)
+	srv := httptest.NewServer(router)
+	t.Cleanup(srv.Close)
+	r.url = srv.URL
+	r.syncer = &Syncer{DBN:"db",Shared:srv.Client(),Tree:r.facade.Tree,Api:r.facade}
+	return r
+}
+
+func row(i, col int) []byte {
+	r := datatypes.Row{}
+	for c := 0; c<8; c++ { r[fmt.Sprint("c",col,c)] = i }
+	return datatypes.Table_Put(r)
+}
+
+func TestConverge(t *testing.T) {
+	a,b := newReplica(t),newReplica(t)
+	for i := 0; i<300; i++ {
+		key := []byte(fmt.Sprint(i))
+		r1,r2 := row(i,1),row(i,2)
+		switch i%3 {
+		case 0: /* Both, in a different order. */
+			a.facade.Submit(key,r1)
+			a.facade.Submit(key,r2)
+			b.facade.Submit(key,r2)
+			b.facade.Submit(key,r1)
+		case 1:
+			a.facade.Submit(key,r1)
+		case 2:
+			b.facade.Submit(key,r2)
+		}
+	}
+	if Build(a.store,6).Root()!=a.facade.Tree.Root() { t.Fatal("tree of a does not match its store") }
+	if ok,err := a.syncer.Converged(b.url); ok || err!=nil { t.Fatalf("converged before sync: %v %v",ok,err) }
+	
+	st,err := a.syncer.SyncWith(b.url)
+	if err!=nil || st.Items!=100 { t.Fatalf("a: %+v %v",st,err) }
+	st,err = b.syncer.SyncWith(a.url)
+	if err!=nil || st.Items!=100 { t.Fatalf("b: %+v %v",st,err) }
+	if ok,err := a.syncer.Converged(b.url); !ok || err!=nil { t.Fatalf("not converged: %v %v",ok,err) }
+	
+	/* Equal rows are stored as equal items, so nothing is fetched again. */
+	for _,r := range []*replica{a,b} {
+		other := b.url
+		if r==b { other = a.url }
+		if st,err := r.syncer.SyncWith(other); err!=nil || st.Leaves!=0 || st.Items!=0 { t.Fatalf("resync: %+v %v",st,err) }
+	}
+	
+	a.facade.Delete([]byte("0"))
+	if Build(a.store,6).Root()!=a.facade.Tree.Root() { t.Fatal("tree of a does not match its store after a delete") }
+}
+
+func TestTruncated(t *testing.T) {
+	a := newReplica(t)
+	b := newReplica(t)
+	for i := 0; i<10; i++ { b.facade.Submit([]byte(fmt.Sprint(i)),row(i,1)) }
+	
+	/* Serves b, but cuts the items response short. */
+	router := httprouter.New()
+	(&Server{DBN:"db",Tree:b.facade.Tree,Api:b.facade}).Register(router)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path!="/db/p2p-m/items" {
+			router.ServeHTTP(w,r)
+			return
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec,r)
+		body := rec.Body.Bytes()
+		w.Write(body[:len(body)-5])
+	}))
+	defer srv.Close()
+	if _,err := a.syncer.SyncWith(srv.URL); err==nil { t.Fatal("partial sync reported as success") }
+}
@@ -0,0 +1,116 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package merkle
+
+import "github.com/julienschmidt/httprouter"
+import "net/http"
+
+import "github.com/byte-mug/brute/api"
+import "github.com/byte-mug/brute/network/auth"
+import "github.com/vmihailenco/msgpack"
+import "bufio"
+import "strconv"
+
+/* The maximum size of a request body. */
+const maxBody = 64<<20
+
+/*
+Serves the Tree to Syncers of other replicas:
+
+	GET  /{DBN}/p2p-m/depth        the depth of the Tree
+	POST /{DBN}/p2p-m/level/:level the hashes of a list of nodes of a level
+	POST /{DBN}/p2p-m/digest       (key, hash) of all keys of a list of leaves
+	POST /{DBN}/p2p-m/items        (key, item) of a list of keys
+*/
+type Server struct{
+	DBN  string
+	Tree *Tree
+	Api  api.StorageFacade
+	
+	/* Optional: if set, requests must be authenticated with the Replicate permission. */
+	Auth *auth.Authenticator
+}
+func (s *Server) depth(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	w.Header().Add("Content-Type","application/x-msgpack")
+	msgpack.NewEncoder(w).EncodeInt(int64(s.Tree.Depth()))
+}
+func (s *Server) level(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	level,err := strconv.Atoi(ps.ByName("level"))
+	if err!=nil {
+		w.WriteHeader(400)
+		return
+	}
+	var nodes []uint32
+	if msgpack.NewDecoder(http.MaxBytesReader(w,r.Body,maxBody)).Decode(&nodes)!=nil {
+		w.WriteHeader(400)
+		return
+	}
+	w.Header().Add("Content-Type","application/x-msgpack")
+	llw := bufio.NewWriter(w)
+	defer llw.Flush()
+	msgpack.NewEncoder(llw).Encode(s.Tree.Hashes(level,nodes))
+}
+func (s *Server) digest(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var leaves []uint32
+	if msgpack.NewDecoder(http.MaxBytesReader(w,r.Body,maxBody)).Decode(&leaves)!=nil {
+		w.WriteHeader(400)
+		return
+	}
+	set := make(map[uint32]bool,len(leaves))
+	for _,l := range leaves { set[l] = true }
+	w.Header().Add("Content-Type","application/x-msgpack")
+	llw := bufio.NewWriter(w)
+	enc := msgpack.NewEncoder(llw)
+	defer llw.Flush()
+	s.Api.Stream(func(key, item []byte){
+		if !set[s.Tree.Leaf(key)] { return }
+		h := pairHash(key,item)
+		enc.EncodeBytes(key)
+		enc.EncodeBytes(h[:])
+	})
+}
+func (s *Server) items(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var keys [][]byte
+	if msgpack.NewDecoder(http.MaxBytesReader(w,r.Body,maxBody)).Decode(&keys)!=nil {
+		w.WriteHeader(400)
+		return
+	}
+	w.Header().Add("Content-Type","application/x-msgpack")
+	llw := bufio.NewWriter(w)
+	enc := msgpack.NewEncoder(llw)
+	defer llw.Flush()
+	for _,key := range keys {
+		item,ok,readable := s.Api.Obtain(key)
+		if !(ok&&readable) { continue }
+		enc.EncodeBytes(key)
+		enc.EncodeBytes(item)
+	}
+}
+func (s *Server) Register(r *httprouter.Router) {
+	p := "/"+s.DBN+"/p2p-m/"
+	r.GET (p+"depth"       ,s.Auth.Guard(auth.Replicate,s.depth ))
+	r.POST(p+"level/:level",s.Auth.Guard(auth.Replicate,s.level ))
+	r.POST(p+"digest"      ,s.Auth.Guard(auth.Replicate,s.digest))
+	r.POST(p+"items"       ,s.Auth.Guard(auth.Replicate,s.items ))
+}
@@ -0,0 +1,187 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package merkle
+
+import "net/http"
+
+import "github.com/byte-mug/brute/api"
+import "github.com/byte-mug/brute/network/auth"
+import "github.com/vmihailenco/msgpack"
+import "bufio"
+import "bytes"
+import "errors"
+import "fmt"
+import "io"
+import "strconv"
+
+var ErrDepth = errors.New("merkle: the trees differ in depth")
+
+type Stats struct{
+	/* Leaves, that differ. */
+	Leaves int
+	
+	/* Keys, whose items differ or are missing locally. */
+	Keys   int
+	
+	/* Items received and submitted. */
+	Items  int
+}
+
+/*
+Pulls the key-item-pairs, that differ, from another replica: it descends both
+trees level by level, only following nodes whose hashes differ, compares the
+keys of the differing leaves and fetches only the items, that differ.
+
+To get both replicas in sync, both have to pull from each other. As items are
+merged, a replica holding a newer item is not affected by a pull.
+*/
+type Syncer struct{
+	DBN    string
+	Shared *http.Client
+	
+	/* The URL scheme used with "host:port" addresses. If "", "http" is used. */
+	Scheme string
+	
+	Tree   *Tree
+	
+	/* The local store; should be the Facade maintaining Tree. */
+	Api    api.StorageFacade
+	
+	/* Optional: the credentials to authenticate with. */
+	Auth   auth.Credentials
+}
+func (s *Syncer) do(method, ue string, body interface{}) (*http.Response,error) {
+	var buf bytes.Buffer
+	if body!=nil {
+		if err := msgpack.NewEncoder(&buf).Encode(body); err!=nil { return nil,err }
+	}
+	req,err := http.NewRequest(method,ue,bytes.NewReader(buf.Bytes()))
+	if err!=nil { return nil,err }
+	if err = auth.Sign(s.Auth,req); err!=nil { return nil,err }
+	resp,err := s.Shared.Do(req)
+	if err!=nil { return nil,err }
+	if resp.StatusCode!=200 {
+		resp.Body.Close()
+		return nil,fmt.Errorf("merkle: status %d",resp.StatusCode)
+	}
+	return resp,nil
+}
+func (s *Syncer) url(addr, path string) string {
+	return auth.BaseURL(s.Scheme,addr)+"/"+s.DBN+"/p2p-m/"+path
+}
+
+func (s *Syncer) hashes(addr string, level int, nodes []uint32) (h [][]byte, err error) {
+	resp,err := s.do("POST",s.url(addr,"level/"+strconv.Itoa(level)),nodes)
+	if err!=nil { return }
+	defer resp.Body.Close()
+	err = msgpack.NewDecoder(bufio.NewReader(resp.Body)).Decode(&h)
+	if err==nil && len(h)!=len(nodes) { err = fmt.Errorf("merkle: got %d hashes for %d nodes",len(h),len(nodes)) }
+	return
+}
+
+/* Returns true, if the root hash of the replica at addr equals the local one. */
+func (s *Syncer) Converged(addr string) (bool,error) {
+	h,err := s.hashes(addr,0,[]uint32{0})
+	if err!=nil { return false,err }
+	root := s.Tree.Root()
+	return bytes.Equal(h[0],root[:]),nil
+}
+
+/* Returns the leaves, that differ from the replica at addr. */
+func (s *Syncer) diff(addr string) ([]uint32,error) {
+	resp,err := s.do("GET",s.url(addr,"depth"),nil)
+	if err!=nil { return nil,err }
+	depth,err := msgpack.NewDecoder(resp.Body).DecodeInt()
+	resp.Body.Close()
+	if err!=nil { return nil,err }
+	if depth!=s.Tree.Depth() { return nil,ErrDepth }
+	
+	nodes := []uint32{0}
+	for level := 0; ; level++ {
+		remote,err := s.hashes(addr,level,nodes)
+		if err!=nil { return nil,err }
+		local := s.Tree.Hashes(level,nodes)
+		var diff []uint32
+		for i,n := range nodes {
+			if !bytes.Equal(local[i],remote[i]) { diff = append(diff,n) }
+		}
+		if level==depth || len(diff)==0 { return diff,nil }
+		nodes = make([]uint32,0,len(diff)*2)
+		for _,n := range diff { nodes = append(nodes,n*2,n*2+1) }
+	}
+}
+
+func (s *Syncer) SyncWith(addr string) (st Stats, err error) {
+	leaves,err := s.diff(addr)
+	st.Leaves = len(leaves)
+	if err!=nil || len(leaves)==0 { return }
+	
+	resp,err := s.do("POST",s.url(addr,"digest"),leaves)
+	if err!=nil { return }
+	remote := make(map[string]string)
+	dec := msgpack.NewDecoder(bufio.NewReader(resp.Body))
+	var key,h []byte
+	i := []interface{}{&key,&h}
+	for {
+		err = dec.DecodeMulti(i...)
+		if err==io.EOF { break }
+		if err!=nil {
+			resp.Body.Close()
+			return
+		}
+		remote[string(key)] = string(h)
+	}
+	resp.Body.Close()
+	err = nil
+	
+	set := make(map[uint32]bool,len(leaves))
+	for _,l := range leaves { set[l] = true }
+	s.Api.Stream(func(key, item []byte){
+		if !set[s.Tree.Leaf(key)] { return }
+		k := string(key)
+		if rh,ok := remote[k]; ok {
+			if lh := pairHash(key,item); rh==string(lh[:]) { delete(remote,k) }
+		}
+	})
+	st.Keys = len(remote)
+	if len(remote)==0 { return }
+	
+	keys := make([][]byte,0,len(remote))
+	for k := range remote { keys = append(keys,[]byte(k)) }
+	resp,err = s.do("POST",s.url(addr,"items"),keys)
+	if err!=nil { return }
+	defer resp.Body.Close()
+	dec = msgpack.NewDecoder(bufio.NewReader(resp.Body))
+	var item []byte
+	i = []interface{}{&key,&item}
+	for {
+		/* The decoder reuses the buffers; Api may keep the slices. */
+		key,item = nil,nil
+		err = dec.DecodeMulti(i...)
+		if err==io.EOF { return st,nil }
+		if err!=nil { return }
+		if !s.Api.Submit(key,item) { err = fmt.Errorf("merkle: local store not writable") ; return }
+		st.Items++
+	}
+}
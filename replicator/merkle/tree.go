@@ -0,0 +1,138 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package merkle
+
+import "github.com/byte-mug/brute/api"
+import "github.com/dgryski/go-farm"
+import "crypto/sha256"
+import "encoding/binary"
+import "sync"
+
+const (
+	/* The default depth of a Tree: 1024 leaves. */
+	DefaultDepth = 10
+	
+	MaxDepth = 24
+)
+
+/* The size of a hash, in bytes. */
+const HashSize = 16
+
+type Hash [HashSize]byte
+
+func (h *Hash) xor(o *Hash) {
+	for i := range h { h[i] ^= o[i] }
+}
+
+/* Hashes a key-item-pair. */
+func pairHash(key, item []byte) (h Hash) {
+	var l [4]byte
+	binary.BigEndian.PutUint32(l[:],uint32(len(key)))
+	d := sha256.New()
+	d.Write(l[:])
+	d.Write(key)
+	d.Write(item)
+	copy(h[:],d.Sum(nil))
+	return
+}
+
+/*
+A hash tree over all key-item-pairs of a store. Keys are assigned to one of
+2^Depth leaves by their hash; a node of level L covers 2^(Depth-L) leaves.
+
+The hash of a node is the XOR of the hashes of all key-item-pairs it covers,
+so the tree can be updated in O(Depth) on every write, independent of the
+order of the writes. Two stores hold the same data, if their roots match.
+
+The items are hashed as stored, so equal data must be stored as equal bytes:
+the datatypes encode their maps with sorted keys. Codecs with nondeterministic
+output, such as the random nonces of layers/crypt, must not be hashed: keep
+the Tree over the plain items, ie. over a codec.Facade, not the store beneath.
+*/
+type Tree struct{
+	depth  int
+	lock   sync.RWMutex
+	levels [][]Hash
+}
+
+/* Creates an empty Tree. If depth is out of range, DefaultDepth is used. */
+func New(depth int) *Tree {
+	if depth<1 || depth>MaxDepth { depth = DefaultDepth }
+	t := &Tree{depth:depth,levels:make([][]Hash,depth+1)}
+	for l := range t.levels { t.levels[l] = make([]Hash,1<<uint(l)) }
+	return t
+}
+
+/* Creates a Tree from all key-item-pairs of s. */
+func Build(s api.StorageFacade, depth int) *Tree {
+	t := New(depth)
+	s.Stream(func(key, item []byte){ t.Update(key,nil,item) })
+	return t
+}
+
+func (t *Tree) Depth() int { return t.depth }
+
+/* Returns the leaf, key is assigned to. */
+func (t *Tree) Leaf(key []byte) uint32 {
+	return uint32(farm.Hash64(key)>>uint(64-t.depth))
+}
+
+/*
+Records, that the item of key changed from 'old' to 'item'. An empty 'old'
+means, that the key did not exist; an empty 'item', that it was removed.
+*/
+func (t *Tree) Update(key, old, item []byte) {
+	var h Hash
+	if len(old)>0 {
+		o := pairHash(key,old)
+		h.xor(&o)
+	}
+	if len(item)>0 {
+		n := pairHash(key,item)
+		h.xor(&n)
+	}
+	leaf := t.Leaf(key)
+	t.lock.Lock(); defer t.lock.Unlock()
+	for l := t.depth; l>=0; l-- {
+		t.levels[l][leaf>>uint(t.depth-l)].xor(&h)
+	}
+}
+
+/* Returns the root hash. */
+func (t *Tree) Root() Hash {
+	t.lock.RLock(); defer t.lock.RUnlock()
+	return t.levels[0][0]
+}
+
+/* Returns the hashes of the given nodes of a level. Invalid nodes yield nil. */
+func (t *Tree) Hashes(level int, nodes []uint32) [][]byte {
+	r := make([][]byte,len(nodes))
+	if level<0 || level>t.depth { return r }
+	t.lock.RLock(); defer t.lock.RUnlock()
+	lv := t.levels[level]
+	for i,n := range nodes {
+		if int(n)<len(lv) { r[i] = append([]byte(nil),lv[n][:]...) }
+	}
+	return r
+}
@@ -0,0 +1,247 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package scheduler
+
+import "github.com/byte-mug/brute/replicator/httpi"
+import "github.com/byte-mug/brute/replicator"
+import "math/rand"
+import "sort"
+import "sync"
+import "time"
+
+const (
+	DefaultInterval    = 10*time.Second
+	DefaultJitter      = 0.2
+	DefaultMaxBackoff  = 5*time.Minute
+	DefaultConcurrency = 4
+)
+
+type Peer struct{
+	Node string
+	Addr string
+}
+
+/* Returns a Peers function for a fixed set of peers. */
+func Static(peers ...Peer) func() []Peer {
+	return func() []Peer { return peers }
+}
+
+type Status struct{
+	Peer
+	
+	LastAttempt time.Time
+	LastSuccess time.Time
+	LastError   error
+	
+	/* The number of consecutive failures. */
+	Failures    int
+	
+	/* The remote version and the local one, at the last poll. */
+	Remote      time.Time
+	Local       time.Time
+	
+	/* How far the local replica lagged behind, at the last poll. */
+	Lag         time.Duration
+	
+	/* The number of SyncWith runs. */
+	Syncs       int64
+	
+	Next        time.Time
+	Running     bool
+}
+
+/*
+Drives a Syncer across all peers: every Interval (plus or minus Jitter), the
+version of each peer is polled, and stale peers are synced with, at most
+Concurrency at a time. After a failure, a peer is retried with exponential
+backoff, up to MaxBackoff.
+*/
+type Scheduler struct{
+	Syncer      *httpi.Syncer
+	
+	/* Returns the peers; called every round, so it may change, eg. driven by gossip. */
+	Peers       func() []Peer
+	
+	Interval    time.Duration
+	
+	/* The fraction, the intervals are randomly shortened or extended by. */
+	Jitter      float64
+	
+	MaxBackoff  time.Duration
+	Concurrency int
+	
+	lock        sync.Mutex
+	status      map[string]*Status
+	stop        chan struct{}
+	done        sync.WaitGroup
+	running     sync.WaitGroup
+}
+func (s *Scheduler) interval() time.Duration {
+	if s.Interval<=0 { return DefaultInterval }
+	return s.Interval
+}
+func (s *Scheduler) maxBackoff() time.Duration {
+	if s.MaxBackoff<=0 { return DefaultMaxBackoff }
+	return s.MaxBackoff
+}
+func (s *Scheduler) concurrency() int {
+	if s.Concurrency<=0 { return DefaultConcurrency }
+	return s.Concurrency
+}
+func (s *Scheduler) jitter(d time.Duration) time.Duration {
+	j := s.Jitter
+	if j<=0 { j = DefaultJitter }
+	if j>1 { j = 1 }
+	return time.Duration(float64(d)*(1+j*(2*rand.Float64()-1)))
+}
+
+/* Returns the delay until the next attempt. Must be called with s.lock held. */
+func (s *Scheduler) delay(st *Status) time.Duration {
+	d := s.interval()
+	for i := 0; i<st.Failures && d<s.maxBackoff(); i++ { d *= 2 }
+	if d>s.maxBackoff() { d = s.maxBackoff() }
+	return s.jitter(d)
+}
+
+/* Starts the scheduler in background. */
+func (s *Scheduler) Start() {
+	s.lock.Lock(); defer s.lock.Unlock()
+	if s.stop!=nil { return }
+	if s.status==nil { s.status = make(map[string]*Status) }
+	s.stop = make(chan struct{})
+	s.done.Add(1)
+	go s.loop(s.stop)
+}
+
+/* Stops the scheduler and waits for running syncs to finish. */
+func (s *Scheduler) Stop() {
+	s.lock.Lock()
+	if s.stop==nil { s.lock.Unlock(); return }
+	close(s.stop)
+	s.stop = nil
+	s.lock.Unlock()
+	s.done.Wait()
+}
+
+/* Returns the status of all peers, sorted by node. */
+func (s *Scheduler) Status() []Status {
+	s.lock.Lock(); defer s.lock.Unlock()
+	r := make([]Status,0,len(s.status))
+	for _,st := range s.status { r = append(r,*st) }
+	sort.Slice(r,func(i,j int) bool { return r[i].Node<r[j].Node })
+	return r
+}
+
+func (s *Scheduler) loop(stop chan struct{}) {
+	defer s.done.Done()
+	defer s.running.Wait()
+	slots := make(chan struct{},s.concurrency())
+	tick := s.interval()/10
+	if tick<10*time.Millisecond { tick = 10*time.Millisecond }
+	t := time.NewTicker(tick)
+	defer t.Stop()
+	for {
+		for _,st := range s.due() {
+			select {
+			case slots <- struct{}{}:
+			case <-stop: return
+			}
+			s.running.Add(1)
+			go func(st *Status){
+				defer s.running.Done()
+				defer func(){ <-slots }()
+				s.run(st)
+			}(st)
+		}
+		select {
+		case <-stop: return
+		case <-t.C:
+		}
+	}
+}
+
+/* Refreshes the peers and returns those due, marking them as running. */
+func (s *Scheduler) due() (r []*Status) {
+	peers := s.Peers()
+	now := time.Now()
+	s.lock.Lock(); defer s.lock.Unlock()
+	seen := make(map[string]bool,len(peers))
+	for _,p := range peers {
+		seen[p.Node] = true
+		st := s.status[p.Node]
+		if st==nil {
+			/* Spread the first round across the interval. */
+			st = &Status{Peer:p,Next:now.Add(time.Duration(rand.Int63n(int64(s.interval())/4+1)))}
+			s.status[p.Node] = st
+		}
+		st.Addr = p.Addr
+		if !st.Running && !now.Before(st.Next) {
+			st.Running = true
+			r = append(r,st)
+		}
+	}
+	for node,st := range s.status {
+		if !seen[node] && !st.Running { delete(s.status,node) }
+	}
+	return
+}
+
+func (s *Scheduler) run(st *Status) {
+	s.lock.Lock()
+	p := st.Peer
+	s.lock.Unlock()
+	
+	begin := time.Now()
+	remote,err := s.Syncer.GetVersion(p.Node,p.Addr)
+	var local time.Time
+	synced := false
+	if err==nil {
+		tvq := &replicator.TimeVecQuery{Node:p.Node}
+		err = s.Syncer.Vec.Query(tvq)
+		local = tvq.Value
+		if err==nil && remote!=nil && remote.After(local) {
+			err = s.Syncer.SyncWith(p.Node,p.Addr,remote)
+			synced = true
+		}
+	}
+	
+	s.lock.Lock(); defer s.lock.Unlock()
+	st.LastAttempt = begin
+	st.LastError = err
+	st.Running = false
+	if synced { st.Syncs++ }
+	if err!=nil {
+		st.Failures++
+	} else {
+		st.Failures = 0
+		st.LastSuccess = time.Now()
+		st.Local = local
+		st.Lag = 0
+		if remote!=nil {
+			st.Remote = *remote
+			if remote.After(local) { st.Lag = remote.Sub(local) }
+		}
+	}
+	st.Next = time.Now().Add(s.delay(st))
+}
@@ -0,0 +1,179 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package scheduler
+
+import "github.com/julienschmidt/httprouter"
+import "github.com/byte-mug/brute/replicator/boltlog"
+import rhttpi "github.com/byte-mug/brute/replicator/httpi"
+import "github.com/byte-mug/brute/replicator"
+import "github.com/byte-mug/brute/datatypes"
+import "github.com/byte-mug/brute/utils"
+import "github.com/byte-mug/brute/api"
+import bolt "github.com/coreos/bbolt"
+import "net/http"
+import "net/http/httptest"
+import "path/filepath"
+import "sync"
+import "testing"
+import "time"
+
+/* An in-memory StorageFacade. */
+type mem struct{
+	utils.MergeUtil
+	lock sync.Mutex
+	m    map[string][]byte
+}
+func newMem(f api.MergerFactory) *mem {
+	m := &mem{m:make(map[string][]byte)}
+	m.Merger = f
+	return m
+}
+func (m *mem) Submit(key, item []byte) bool {
+	m.lock.Lock(); defer m.lock.Unlock()
+	if o,ok := m.m[string(key)]; ok { item,_ = m.Merge(o,item) }
+	m.m[string(key)] = item
+	return true
+}
+func (m *mem) Obtain(key []byte) ([]byte,bool,bool) {
+	m.lock.Lock(); defer m.lock.Unlock()
+	item,ok := m.m[string(key)]
+	return item,ok,true
+}
+func (m *mem) Stream(f func(key, item []byte)) {
+	m.lock.Lock(); defer m.lock.Unlock()
+	for k,v := range m.m { f([]byte(k),v) }
+}
+
+/* An in-memory TimeVec. */
+type vec struct{
+	lock sync.Mutex
+	m    map[string]time.Time
+}
+func (v *vec) Query(t *replicator.TimeVecQuery) error {
+	v.lock.Lock(); defer v.lock.Unlock()
+	t.Value,t.Exist = v.m[t.Node]
+	return nil
+}
+func (v *vec) Update(t *replicator.TimeVecQuery) error {
+	v.lock.Lock(); defer v.lock.Unlock()
+	if v.m==nil { v.m = make(map[string]time.Time) }
+	v.m[t.Node] = t.Value
+	t.Exist = true
+	return nil
+}
+func (v *vec) Extract(r map[string]time.Time) error {
+	v.lock.Lock(); defer v.lock.Unlock()
+	for n,t := range v.m { r[n] = t }
+	return nil
+}
+
+/* A replica serving its update log. */
+type peer struct{
+	api *mem
+	log *boltlog.BoltLocalUpdateLog
+	vec *vec
+	url string
+}
+func newPeer(t *testing.T, node string) *peer {
+	db,err := bolt.Open(filepath.Join(t.TempDir(),"log.db"),0600,nil)
+	if err!=nil { t.Fatal(err) }
+	t.Cleanup(func(){ db.Close() })
+	p := &peer{api:newMem(datatypes.LWW_Factory),vec:new(vec)}
+	p.log = &boltlog.BoltLocalUpdateLog{DB:db,Table:[]byte("t"),Index:[]byte("i")}
+	router := httprouter.New()
+	(&rhttpi.Server{Node:node,DBN:"db",Vec:p.vec,Log:p.log,Api:p.api}).Register(router)
+	srv := httptest.NewServer(router)
+	t.Cleanup(srv.Close)
+	p.url = srv.URL
+	return p
+}
+func (p *peer) put(t *testing.T, node, key string) {
+	now := time.Now().UTC()
+	p.api.Submit([]byte(key),datatypes.LWW_Put([]byte("v")))
+	if err := p.log.Update(&replicator.LocalUpdateEntry{Key:[]byte(key),Change:now}); err!=nil { t.Fatal(err) }
+	p.vec.Update(&replicator.TimeVecQuery{Node:node,Value:now})
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	deadline := time.Now().Add(5*time.Second)
+	for !cond() {
+		if time.Now().After(deadline) { t.Fatalf("timed out waiting for %s",what) }
+		time.Sleep(5*time.Millisecond)
+	}
+}
+
+func TestScheduler(t *testing.T) {
+	a := newPeer(t,"a")
+	a.put(t,"a","k1")
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	
+	var lock sync.Mutex
+	peers := []Peer{{"a",a.url},{"b",down.URL}}
+	
+	local := newMem(datatypes.LWW_Factory)
+	sy := &rhttpi.Syncer{DBN:"db",Shared:http.DefaultClient,Vec:new(vec),Api:local}
+	s := &Scheduler{Syncer:sy,Interval:20*time.Millisecond,MaxBackoff:80*time.Millisecond,
+		Peers:func() []Peer {
+			lock.Lock(); defer lock.Unlock()
+			return peers
+		}}
+	s.Start()
+	defer s.Stop()
+	
+	has := func(key string) func() bool {
+		return func() bool {
+			_,ok,_ := local.Obtain([]byte(key))
+			return ok
+		}
+	}
+	waitFor(t,"k1",has("k1"))
+	waitFor(t,"failures of b",func() bool { return s.Status()[1].Failures>=3 })
+	
+	/* Polls of an unchanged peer do not sync. */
+	st := s.Status()
+	if st[0].Syncs!=1 || st[0].LastError!=nil || st[0].Failures!=0 || st[0].Lag!=0 { t.Fatalf("a: %+v",st[0]) }
+	if st[1].LastError==nil || st[1].Syncs!=0 { t.Fatalf("b: %+v",st[1]) }
+	
+	a.put(t,"a","k2")
+	waitFor(t,"k2",has("k2"))
+	waitFor(t,"the second sync",func() bool { return s.Status()[0].Syncs==2 })
+	
+	/* Peers, that are gone, are dropped. */
+	lock.Lock()
+	peers = peers[:1]
+	lock.Unlock()
+	waitFor(t,"b to be dropped",func() bool { return len(s.Status())==1 })
+}
+
+func TestDelay(t *testing.T) {
+	s := &Scheduler{Interval:100*time.Millisecond,MaxBackoff:time.Second,Jitter:0.1}
+	for f,want := range []time.Duration{100,200,400,800,1000,1000} {
+		want *= time.Millisecond
+		for i := 0; i<20; i++ {
+			d := s.delay(&Status{Failures:f})
+			if d<want*9/10 || d>want*11/10 { t.Fatalf("delay after %d failures: %v, want %v±10%%",f,d,want) }
+		}
+	}
+}
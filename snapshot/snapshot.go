@@ -0,0 +1,229 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package snapshot
+
+import "github.com/byte-mug/brute/replicator"
+import "github.com/byte-mug/brute/api"
+import "github.com/vmihailenco/msgpack"
+import "hash/crc32"
+import "bufio"
+import "bytes"
+import "errors"
+import "io"
+import "time"
+
+/*
+A snapshot file starts with the Magic, followed by a sequence of msgpack-encoded
+frames. Every frame is a (payload,crc32) pair, the payload being a msgpack-encoded
+sequence, starting with the frame type.
+
+The first frame is the header, the last frame is the trailer, that holds the
+number of frames of each type, so that truncated snapshots are detected.
+
+	fHeader  => (Version,Datatype,Node,Created)
+	fItem    => (key,item)
+	fVec     => (node,time)
+	fLog     => (key,change)
+	fTrailer => (items,vecs,logs)
+*/
+const Magic = "BRUTESNP"
+
+const Version = 1
+
+const (
+	fHeader uint8 = iota
+	fItem
+	fVec
+	fLog
+	fTrailer
+)
+
+var (
+	ErrMagic     = errors.New("snapshot: not a snapshot file")
+	ErrVersion   = errors.New("snapshot: unsupported version")
+	ErrChecksum  = errors.New("snapshot: checksum mismatch")
+	ErrFrame     = errors.New("snapshot: unexpected frame")
+	ErrTruncated = errors.New("snapshot: truncated")
+	ErrDatatype  = errors.New("snapshot: datatype mismatch")
+)
+
+type Header struct{
+	Version  int
+	
+	/* A free-form name of the datatype, such as "lww" or "table". */
+	Datatype string
+	
+	/* The node, the snapshot has been taken from. */
+	Node     string
+	
+	Created  time.Time
+}
+
+type writer struct{
+	enc  *msgpack.Encoder
+	buf  bytes.Buffer
+	penc *msgpack.Encoder
+	err  error
+}
+func (w *writer) frame(v ...interface{}) {
+	if w.err!=nil { return }
+	w.buf.Reset()
+	if w.err = w.penc.EncodeMulti(v...); w.err!=nil { return }
+	w.err = w.enc.EncodeMulti(w.buf.Bytes(),crc32.ChecksumIEEE(w.buf.Bytes()))
+}
+
+/* Writes a snapshot of a StorageFacade. */
+type Exporter struct{
+	Header
+	Api api.StorageFacade
+	
+	/* Optional: if set, the update log is included into the snapshot. */
+	Log replicator.LocalUpdateLog
+	
+	/* Optional: if set, the TimeVec is included into the snapshot. */
+	Vec replicator.TimeVec
+}
+func (e *Exporter) Export(dst io.Writer) error {
+	bw := bufio.NewWriter(dst)
+	if _,err := bw.WriteString(Magic); err!=nil { return err }
+	w := &writer{enc:msgpack.NewEncoder(bw)}
+	w.penc = msgpack.NewEncoder(&w.buf)
+	
+	created := e.Created
+	if created.IsZero() { created = time.Now().UTC() }
+	w.frame(fHeader,Version,e.Datatype,e.Node,created)
+	
+	var items,vecs,logs int
+	e.Api.Stream(func(key, item []byte){
+		w.frame(fItem,key,item)
+		items++
+	})
+	
+	if e.Vec!=nil && w.err==nil {
+		m := make(map[string]time.Time)
+		if err := e.Vec.Extract(m); err!=nil { return err }
+		for node,t := range m {
+			w.frame(fVec,node,t)
+			vecs++
+		}
+	}
+	
+	if e.Log!=nil && w.err==nil {
+		lues := make(chan replicator.LocalUpdateEntry,1024)
+		if err := e.Log.ReadAllAsync(time.Time{},lues); err!=nil { return err }
+		for lue := range lues {
+			w.frame(fLog,lue.Key,lue.Change)
+			logs++
+		}
+	}
+	
+	w.frame(fTrailer,items,vecs,logs)
+	if w.err!=nil { return w.err }
+	return bw.Flush()
+}
+
+/* Reads a snapshot into a StorageFacade, merging it with the existing data. */
+type Importer struct{
+	Api api.StorageFacade
+	
+	/* Optional: if set, the snapshot must have the same datatype. */
+	Datatype string
+	
+	/* Optional: if set, the update log of the snapshot is merged into it. */
+	Log replicator.LocalUpdateLog
+	
+	/* Optional: if set, the TimeVec of the snapshot is merged into it. */
+	Vec replicator.TimeVec
+}
+func (i *Importer) vec(node string, t time.Time) error {
+	tvq := &replicator.TimeVecQuery{Node:node}
+	if err := i.Vec.Query(tvq); err!=nil { return err }
+	if tvq.Exist && !tvq.Value.Before(t) { return nil }
+	tvq.Value = t
+	return i.Vec.Update(tvq)
+}
+func (i *Importer) log(key []byte, t time.Time) error {
+	lue := &replicator.LocalUpdateEntry{Key:key}
+	if err := i.Log.Query(lue); err!=nil { return err }
+	if lue.Exist && !lue.Change.Before(t) { return nil }
+	lue.Change = t
+	return i.Log.Update(lue)
+}
+func (i *Importer) Import(src io.Reader) (*Header,error) {
+	br := bufio.NewReader(src)
+	magic := make([]byte,len(Magic))
+	if _,err := io.ReadFull(br,magic); err!=nil || string(magic)!=Magic { return nil,ErrMagic }
+	dec := msgpack.NewDecoder(br)
+	
+	var payload []byte
+	var sum uint32
+	var kind uint8
+	var h *Header
+	var items,vecs,logs int
+	for {
+		if err := dec.DecodeMulti(&payload,&sum); err!=nil {
+			if err==io.EOF || err==io.ErrUnexpectedEOF { return h,ErrTruncated }
+			return h,err
+		}
+		if crc32.ChecksumIEEE(payload)!=sum { return h,ErrChecksum }
+		pdec := msgpack.NewDecoder(bytes.NewReader(payload))
+		if err := pdec.Decode(&kind); err!=nil { return h,err }
+		if (h==nil) != (kind==fHeader) { return h,ErrFrame }
+		switch kind {
+		case fHeader:
+			h = new(Header)
+			if err := pdec.DecodeMulti(&h.Version,&h.Datatype,&h.Node,&h.Created); err!=nil { return nil,err }
+			if h.Version!=Version { return h,ErrVersion }
+			if i.Datatype!="" && i.Datatype!=h.Datatype { return h,ErrDatatype }
+		case fItem:
+			var key,item []byte
+			if err := pdec.DecodeMulti(&key,&item); err!=nil { return h,err }
+			if !i.Api.Submit(key,item) { return h,errors.New("snapshot: submit failed") }
+			items++
+		case fVec:
+			var node string
+			var t time.Time
+			if err := pdec.DecodeMulti(&node,&t); err!=nil { return h,err }
+			if i.Vec!=nil {
+				if err := i.vec(node,t); err!=nil { return h,err }
+			}
+			vecs++
+		case fLog:
+			var key []byte
+			var t time.Time
+			if err := pdec.DecodeMulti(&key,&t); err!=nil { return h,err }
+			if i.Log!=nil {
+				if err := i.log(key,t); err!=nil { return h,err }
+			}
+			logs++
+		case fTrailer:
+			var ti,tv,tl int
+			if err := pdec.DecodeMulti(&ti,&tv,&tl); err!=nil { return h,err }
+			if ti!=items || tv!=vecs || tl!=logs { return h,ErrTruncated }
+			return h,nil
+		default:
+			return h,ErrFrame
+		}
+	}
+}
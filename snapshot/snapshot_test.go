@@ -0,0 +1,146 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package snapshot
+
+import bolt "github.com/coreos/bbolt"
+import "github.com/byte-mug/brute/replicator/boltlog"
+import "github.com/byte-mug/brute/replicator"
+import "github.com/byte-mug/brute/datatypes"
+import "github.com/byte-mug/brute/utils"
+import "github.com/byte-mug/brute/api"
+import "bytes"
+import "path/filepath"
+import "sort"
+import "sync"
+import "testing"
+import "time"
+
+/* An in-memory StorageFacade. */
+type mem struct{
+	utils.MergeUtil
+	lock sync.Mutex
+	m    map[string][]byte
+}
+func newMem(f api.MergerFactory) *mem {
+	m := &mem{m:make(map[string][]byte)}
+	m.Merger = f
+	return m
+}
+func (m *mem) Submit(key, item []byte) bool {
+	m.lock.Lock(); defer m.lock.Unlock()
+	if o,ok := m.m[string(key)]; ok { item,_ = m.Merge(o,item) }
+	m.m[string(key)] = item
+	return true
+}
+func (m *mem) Obtain(key []byte) ([]byte,bool,bool) {
+	m.lock.Lock(); defer m.lock.Unlock()
+	item,ok := m.m[string(key)]
+	return item,ok,true
+}
+func (m *mem) Stream(f func(key, item []byte)) {
+	m.lock.Lock()
+	keys := make([]string,0,len(m.m))
+	for k := range m.m { keys = append(keys,k) }
+	m.lock.Unlock()
+	sort.Strings(keys)
+	for _,k := range keys {
+		item,_,_ := m.Obtain([]byte(k))
+		f([]byte(k),item)
+	}
+}
+
+func openLog(t *testing.T) *boltlog.BoltLocalUpdateLog {
+	db,err := bolt.Open(filepath.Join(t.TempDir(),"log.db"),0600,nil)
+	if err!=nil { t.Fatal(err) }
+	t.Cleanup(func(){ db.Close() })
+	return &boltlog.BoltLocalUpdateLog{DB:db,Table:[]byte("t"),Index:[]byte("i")}
+}
+
+/* Runs f, failing the test, if it does not return within a few seconds. */
+func within(t *testing.T, f func() error) error {
+	ch := make(chan error,1)
+	go func(){ ch <- f() }()
+	select {
+	case err := <-ch: return err
+	case <-time.After(5*time.Second):
+		t.Fatal("timed out")
+		return nil
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	src := newMem(datatypes.LWW_Factory)
+	log := openLog(t)
+	for _,k := range []string{"a","b","c"} {
+		src.Submit([]byte(k),datatypes.LWW_Put([]byte(k+"v")))
+		if err := log.Update(&replicator.LocalUpdateEntry{Key:[]byte(k),Change:time.Now()}); err!=nil { t.Fatal(err) }
+	}
+	var buf bytes.Buffer
+	e := &Exporter{Api:src,Log:log}
+	e.Datatype = "lww"
+	e.Node = "n1"
+	if err := within(t,func() error { return e.Export(&buf) }); err!=nil { t.Fatal(err) }
+	
+	dst := newMem(datatypes.LWW_Factory)
+	dlog := openLog(t)
+	h,err := (&Importer{Api:dst,Datatype:"lww",Log:dlog}).Import(bytes.NewReader(buf.Bytes()))
+	if err!=nil { t.Fatal(err) }
+	if h.Node!="n1" { t.Fatalf("node %q",h.Node) }
+	for _,k := range []string{"a","b","c"} {
+		v,ok := datatypes.LWW_Decode(dst.Obtain([]byte(k)))
+		if !ok || string(v)!=k+"v" { t.Fatalf("%s: got %q",k,v) }
+		lue := &replicator.LocalUpdateEntry{Key:[]byte(k)}
+		if err := dlog.Query(lue); err!=nil || !lue.Exist { t.Fatalf("%s: not logged: %v",k,err) }
+	}
+	
+	if _,err := (&Importer{Api:newMem(datatypes.LWW_Factory),Datatype:"table"}).Import(bytes.NewReader(buf.Bytes())); err!=ErrDatatype {
+		t.Fatalf("got %v, want ErrDatatype",err)
+	}
+}
+
+/* An update log, that has never been written to, has no Index bucket. */
+func TestExportEmptyLog(t *testing.T) {
+	src := newMem(datatypes.LWW_Factory)
+	src.Submit([]byte("a"),datatypes.LWW_Put([]byte("1")))
+	var buf bytes.Buffer
+	e := &Exporter{Api:src,Log:openLog(t)}
+	if err := within(t,func() error { return e.Export(&buf) }); err!=nil { t.Fatal(err) }
+	if _,err := (&Importer{Api:newMem(datatypes.LWW_Factory)}).Import(bytes.NewReader(buf.Bytes())); err!=nil { t.Fatal(err) }
+}
+
+func TestCorrupt(t *testing.T) {
+	src := newMem(datatypes.LWW_Factory)
+	src.Submit([]byte("a"),datatypes.LWW_Put([]byte("1")))
+	var buf bytes.Buffer
+	if err := (&Exporter{Api:src}).Export(&buf); err!=nil { t.Fatal(err) }
+	b := buf.Bytes()
+	
+	if _,err := (&Importer{Api:newMem(datatypes.LWW_Factory)}).Import(bytes.NewReader(b[:len(b)-4])); err!=ErrTruncated {
+		t.Fatalf("truncated: got %v",err)
+	}
+	b[len(Magic)+4] ^= 1
+	if _,err := (&Importer{Api:newMem(datatypes.LWW_Factory)}).Import(bytes.NewReader(b)); err==nil {
+		t.Fatal("corrupt snapshot imported")
+	}
+}